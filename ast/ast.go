@@ -21,14 +21,25 @@ type Expression interface {
 	expressionNode()
 }
 
+// Custom is embedded by node types declared outside this package, such as
+// the ones parser extensions add, to make them Expressions and Statements.
+// The evaluator needs to be told how to run them; see
+// evaluator.RegisterNodeType
+type Custom struct{}
+
+func (Custom) expressionNode() {}
+func (Custom) statementNode()  {}
+
 type Program struct {
 	Statements []Statement
 }
 
 type LetStatement struct {
-	Token token.Token
+	Token token.Token // the 'let' or 'const' token
 	Name  *Identifier // the variable name to bind data to
+	Type  *TypeExpr   // nil if not annotated
 	Value Expression
+	Const bool
 }
 type ReturnStatement struct {
 	Token       token.Token
@@ -40,7 +51,11 @@ type ExpressionStatement struct {
 }
 type FunctionLiteral struct {
 	Token      token.Token
+	Name       string // "" for anonymous functions
+	Generator  bool   // declared w/ fn* or contains a yield
 	Parameters []*Identifier
+	ParamTypes []*TypeExpr // parallel to Parameters, nil where not annotated
+	ReturnType *TypeExpr
 	Body       *BlockStatement
 }
 
@@ -48,6 +63,7 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	Tail      bool // the call's result is its function's result, see MarkTailCalls
 }
 type Identifier struct {
 	Token token.Token // IDENT type token
@@ -90,6 +106,14 @@ type StringLiteral struct {
 	Value string
 }
 
+// InterpolatedString is a string w/ embedded expressions, e.g.
+// `"count: ${n + 1}"`. Parts alternate between text, as *StringLiterals, and
+// the embedded expressions, starting and ending w/ text
+type InterpolatedString struct {
+	Token token.Token // the TEMPLATE_HEAD token
+	Parts []Expression
+}
+
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
@@ -106,6 +130,191 @@ type HashLiteral struct {
 	Pairs map[Expression]Expression
 }
 
+type NullLiteral struct {
+	Token token.Token
+}
+
+type SymbolLiteral struct {
+	Token token.Token // SYMBOL type token
+	Value string      // name without the leading colon
+}
+
+type MatchExpression struct {
+	Token   token.Token // the 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+type StructStatement struct {
+	Token  token.Token // the 'struct' token
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+type ImplStatement struct {
+	Token   token.Token // the 'impl' token
+	Name    *Identifier // the struct or enum the methods are attached to
+	Methods []*MethodDefinition
+}
+
+type EnumStatement struct {
+	Token    token.Token // the 'enum' token
+	Name     *Identifier
+	Variants []*EnumVariant
+}
+
+type EnumVariant struct {
+	Name   *Identifier
+	Fields []*Identifier // empty for unit variants
+}
+
+type MethodDefinition struct {
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+type MemberExpression struct {
+	Token    token.Token // the '.' token
+	Object   Expression
+	Property *Identifier
+}
+
+type AssignExpression struct {
+	Token  token.Token // the '=' token
+	Target Expression  // an *Identifier or *MemberExpression
+	Value  Expression
+}
+
+// PropagateExpression is the postfix `?` operator: it unwraps Some/Ok and
+// returns None/Err from the enclosing function
+type PropagateExpression struct {
+	Token token.Token // the '?' token
+	Left  Expression
+}
+
+type TryExpression struct {
+	Token      token.Token // the 'try' token
+	Block      *BlockStatement
+	CatchParam *Identifier // nil for a bare `catch { ... }`
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+// FunctionStatement is a `fn name(...) {...}` declaration. Declarations are
+// hoisted, so they're visible throughout the block they appear in
+type FunctionStatement struct {
+	Token    token.Token // the 'fn' token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+type YieldExpression struct {
+	Token token.Token // the 'yield' token
+	Value Expression
+}
+
+type ForExpression struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+type SpawnExpression struct {
+	Token token.Token // the 'spawn' token
+	Call  *CallExpression
+}
+
+type AwaitExpression struct {
+	Token token.Token // the 'await' token
+	Value Expression
+}
+
+// SelectExpression waits on several channel operations at once, e.g.
+// `select { v = recv(a) => v, send(b, 1) => 0, _ => -1 }`
+type SelectExpression struct {
+	Token   token.Token // the 'select' token
+	Cases   []*SelectCase
+	Default *BlockStatement // the `_` arm, if any
+}
+
+// A SelectCase is either `recv(ch)`, optionally bound as `name = recv(ch)`,
+// or `send(ch, value)`
+type SelectCase struct {
+	Token   token.Token // the 'recv' or 'send' token
+	Binding *Identifier // nil unless a recv is bound
+	Channel Expression
+	Value   Expression // nil for recv
+	Body    *BlockStatement
+}
+
+// ImportStatement is `import "path" as name`. W/o `as`, the module is bound
+// under its file name minus the extension
+type ImportStatement struct {
+	Token token.Token // the 'import' token
+	Path  string
+	Alias *Identifier // nil if not given
+}
+
+// ExportStatement marks the binding its statement declares as visible to
+// modules importing this one
+type ExportStatement struct {
+	Token     token.Token // the 'export' token
+	Statement Statement
+}
+
+// ExportedName is the name an exported statement binds
+func (es *ExportStatement) ExportedName() string {
+	switch stmt := es.Statement.(type) {
+	case *LetStatement:
+		return stmt.Name.Value
+	case *FunctionStatement:
+		return stmt.Name.Value
+	case *StructStatement:
+		return stmt.Name.Value
+	case *EnumStatement:
+		return stmt.Name.Value
+	}
+	return ""
+}
+
+type DeferStatement struct {
+	Token token.Token // the 'defer' token
+	Call  *CallExpression
+}
+
+// MacroLiteral is `macro(params) { body }`. Macros are bound by top-level
+// let statements and expanded before the program runs: the body gets its
+// arguments unevaluated, as quotes, and returns the quote to splice in
+type MacroLiteral struct {
+	Token      token.Token // the 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+// InfixStatement declares a user-defined operator, e.g.
+// `infix <> (precedence: SUM, assoc: left) = fn(a, b) {...}`. `a <> b` then
+// parses as an InfixExpression that calls the function
+type InfixStatement struct {
+	Token      token.Token // the 'infix' token
+	Operator   string
+	Precedence string // one of the parser's precedence names, e.g. SUM
+	Assoc      string // "left" or "right"
+	Function   Expression
+}
+
+// A MatchArm is `pattern => body`. Patterns are parsed as ordinary
+// expressions and interpreted by the evaluator
+type MatchArm struct {
+	Pattern Expression
+	Body    *BlockStatement
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -120,6 +329,23 @@ func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
 
+func (ml *MacroLiteral) expressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) String() string {
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+	return ml.TokenLiteral() + "(" + strings.Join(params, ", ") + ")" + ml.Body.String()
+}
+
+func (is *InfixStatement) statementNode()       {}
+func (is *InfixStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *InfixStatement) String() string {
+	return is.TokenLiteral() + " " + is.Operator + " (precedence: " + is.Precedence +
+		", assoc: " + is.Assoc + ") = " + is.Function.String() + ";"
+}
+
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 func (ls *LetStatement) String() string {
@@ -128,6 +354,9 @@ func (ls *LetStatement) String() string {
 	// Should print let [IDENT] = [VALUE];
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -220,19 +449,77 @@ func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
-	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
 
 	out.WriteString(fl.TokenLiteral())
-	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(")")
+	if fl.Generator {
+		out.WriteString("*")
+	}
+	if fl.Name != "" {
+		out.WriteString(" " + fl.Name)
+	}
+	out.WriteString(fl.signature())
+	if fl.ReturnType != nil {
+		out.WriteString(" ")
+	}
 	out.WriteString(fl.Body.String())
 	return out.String()
 }
 
+// signature is the parameter list and return type w/ their annotations,
+// e.g. `(a: int, b) -> int`
+func (fl *FunctionLiteral) signature() string {
+	params := []string{}
+	for ix, p := range fl.Parameters {
+		if t := fl.ParamType(ix); t != nil {
+			params = append(params, p.String()+": "+t.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	sig := "(" + strings.Join(params, ", ") + ")"
+	if fl.ReturnType != nil {
+		sig += " -> " + fl.ReturnType.String()
+	}
+	return sig
+}
+
+// ParamType is the annotated type of the ix-th parameter, nil if it has none
+func (fl *FunctionLiteral) ParamType(ix int) *TypeExpr {
+	if ix >= len(fl.ParamTypes) {
+		return nil
+	}
+	return fl.ParamTypes[ix]
+}
+
+// TypeExpr is a type annotation. Annotations are only read by the type
+// checker; the evaluator ignores them
+type TypeExpr struct {
+	Token  token.Token
+	Name   string      // int, array, Option, a struct name, ...; "fn" and "|" for fn and union types
+	Params []*TypeExpr // type arguments, fn parameter types or union members
+	Return *TypeExpr   // fn types only
+}
+
+func (te *TypeExpr) String() string {
+	params := []string{}
+	for _, p := range te.Params {
+		params = append(params, p.String())
+	}
+	switch {
+	case te.Name == "|":
+		return strings.Join(params, " | ")
+	case te.Name == "fn":
+		out := "fn(" + strings.Join(params, ", ") + ")"
+		if te.Return != nil {
+			out += " -> " + te.Return.String()
+		}
+		return out
+	case len(params) > 0:
+		return te.Name + "[" + strings.Join(params, ", ") + "]"
+	}
+	return te.Name
+}
+
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 func (ce *CallExpression) String() string {
@@ -268,6 +555,22 @@ func (s *StringLiteral) expressionNode()      {}
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
 func (s *StringLiteral) String() string       { return s.Token.Literal }
 
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+	out.WriteString("\"")
+	for ix, part := range is.Parts {
+		if ix%2 == 0 {
+			out.WriteString(part.String())
+		} else {
+			out.WriteString("${" + part.String() + "}")
+		}
+	}
+	out.WriteString("\"")
+	return out.String()
+}
+
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
 func (al *ArrayLiteral) String() string {
@@ -314,6 +617,230 @@ func (hl *HashLiteral) String() string {
 	return out.String()
 }
 
+func (nl *NullLiteral) expressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) String() string       { return nl.Token.Literal }
+
+func (sl *SymbolLiteral) expressionNode()      {}
+func (sl *SymbolLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *SymbolLiteral) String() string       { return ":" + sl.Value }
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+
+	out.WriteString("match ")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (ma *MatchArm) String() string {
+	return ma.Pattern.String() + " => " + ma.Body.String()
+}
+
+func (ss *StructStatement) statementNode()       {}
+func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *StructStatement) String() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, f := range ss.Fields {
+		fields = append(fields, f.String())
+	}
+
+	out.WriteString("struct ")
+	out.WriteString(ss.Name.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (is *ImplStatement) statementNode()       {}
+func (is *ImplStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImplStatement) String() string {
+	var out bytes.Buffer
+
+	methods := []string{}
+	for _, m := range is.Methods {
+		methods = append(methods, m.String())
+	}
+
+	out.WriteString("impl ")
+	out.WriteString(is.Name.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(methods, " "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (es *EnumStatement) statementNode()       {}
+func (es *EnumStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *EnumStatement) String() string {
+	var out bytes.Buffer
+
+	variants := []string{}
+	for _, v := range es.Variants {
+		variants = append(variants, v.String())
+	}
+
+	out.WriteString("enum ")
+	out.WriteString(es.Name.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(variants, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (ev *EnumVariant) String() string {
+	if len(ev.Fields) == 0 {
+		return ev.Name.String()
+	}
+	fields := []string{}
+	for _, f := range ev.Fields {
+		fields = append(fields, f.String())
+	}
+	return ev.Name.String() + "(" + strings.Join(fields, ", ") + ")"
+}
+
+func (md *MethodDefinition) String() string {
+	return "fn " + md.Name.String() + md.Function.signature() + " " +
+		md.Function.Body.String()
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) String() string {
+	return me.Object.String() + "." + me.Property.String()
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.CatchParam != nil {
+			out.WriteString("(" + te.CatchParam.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+	return out.String()
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+func (fs *FunctionStatement) statementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) String() string       { return fs.Function.String() }
+
+func (ye *YieldExpression) expressionNode()      {}
+func (ye *YieldExpression) TokenLiteral() string { return ye.Token.Literal }
+func (ye *YieldExpression) String() string {
+	return "(" + ye.TokenLiteral() + " " + ye.Value.String() + ")"
+}
+
+func (fe *ForExpression) expressionNode()      {}
+func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) String() string {
+	return "for (" + fe.Variable.String() + " in " + fe.Iterable.String() + ") " + fe.Body.String()
+}
+
+func (se *SpawnExpression) expressionNode()      {}
+func (se *SpawnExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpawnExpression) String() string {
+	return "(" + se.TokenLiteral() + " " + se.Call.String() + ")"
+}
+
+func (ae *AwaitExpression) expressionNode()      {}
+func (ae *AwaitExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AwaitExpression) String() string {
+	return "(" + ae.TokenLiteral() + " " + ae.Value.String() + ")"
+}
+
+func (se *SelectExpression) expressionNode()      {}
+func (se *SelectExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SelectExpression) String() string {
+	cases := []string{}
+	for _, c := range se.Cases {
+		cases = append(cases, c.String())
+	}
+	if se.Default != nil {
+		cases = append(cases, "_ => "+se.Default.String())
+	}
+	return "select {" + strings.Join(cases, ", ") + "}"
+}
+
+func (sc *SelectCase) String() string {
+	var op string
+	if sc.Value != nil {
+		op = "send(" + sc.Channel.String() + ", " + sc.Value.String() + ")"
+	} else {
+		op = "recv(" + sc.Channel.String() + ")"
+	}
+	if sc.Binding != nil {
+		op = sc.Binding.String() + " = " + op
+	}
+	return op + " => " + sc.Body.String()
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) String() string {
+	out := is.TokenLiteral() + " \"" + is.Path + "\""
+	if is.Alias != nil {
+		out += " as " + is.Alias.String()
+	}
+	return out + ";"
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+func (ds *DeferStatement) statementNode()       {}
+func (ds *DeferStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DeferStatement) String() string {
+	return ds.TokenLiteral() + " " + ds.Call.String() + ";"
+}
+
+func (pe *PropagateExpression) expressionNode()      {}
+func (pe *PropagateExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PropagateExpression) String() string {
+	return "(" + pe.Left.String() + "?)"
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) String() string {
+	return ae.Target.String() + " = " + ae.Value.String()
+}
+
 // let x = 10; would be represented by an AST of:
 // (Program
 //		(LetStatment)
@@ -329,3 +856,59 @@ let x = 10; would be represented by an AST of:
 )
 
 */
+
+// MarkTailCalls flags the calls in a function body whose result becomes the
+// function's result: the last expression, the value of a `return`, and the
+// same positions inside if/match blocks there. Calls inside try blocks are
+// left alone since catch/finally still have to run after them
+func MarkTailCalls(body *BlockStatement) {
+	markReturns(body)
+	markLast(body)
+}
+
+func markLast(block *BlockStatement) {
+	if block == nil || len(block.Statements) == 0 {
+		return
+	}
+	if stmt, ok := block.Statements[len(block.Statements)-1].(*ExpressionStatement); ok {
+		markTail(stmt.Expression)
+	}
+}
+
+func markTail(expr Expression) {
+	switch expr := expr.(type) {
+	case *CallExpression:
+		expr.Tail = true
+	case *IfExpression:
+		markLast(expr.Consequence)
+		markLast(expr.Alternative)
+	case *MatchExpression:
+		for _, arm := range expr.Arms {
+			markLast(arm.Body)
+		}
+	}
+}
+
+func markReturns(block *BlockStatement) {
+	if block == nil {
+		return
+	}
+	for _, stmt := range block.Statements {
+		switch stmt := stmt.(type) {
+		case *ReturnStatement:
+			markTail(stmt.ReturnValue)
+		case *ExpressionStatement:
+			switch expr := stmt.Expression.(type) {
+			case *IfExpression:
+				markReturns(expr.Consequence)
+				markReturns(expr.Alternative)
+			case *MatchExpression:
+				for _, arm := range expr.Arms {
+					markReturns(arm.Body)
+				}
+			case *ForExpression:
+				markReturns(expr.Body)
+			}
+		}
+	}
+}
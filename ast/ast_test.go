@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"fmt"
 	"lang/token"
 	"testing"
 )
@@ -21,3 +22,59 @@ func TestString(t *testing.T) {
 	}
 
 }
+
+func TestModify(t *testing.T) {
+	integer := func(value int64) *IntegerLiteral {
+		return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: fmt.Sprint(value)}, Value: value}
+	}
+	one := func() Expression { return integer(1) }
+	two := func() Expression { return integer(2) }
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok || integer.Value != 1 {
+			return node
+		}
+		return two()
+	}
+
+	tests := []struct {
+		input    Node
+		expected string
+	}{
+		{one(), "2"},
+		{&Program{Statements: []Statement{&ExpressionStatement{Expression: one()}}}, "2"},
+		{&InfixExpression{Left: one(), Operator: "+", Right: two()}, "(2 + 2)"},
+		{&PrefixExpression{Operator: "-", Right: one()}, "(-2)"},
+		{&IndexExpression{Left: one(), Index: one()}, "(2[2])"},
+		{&IfExpression{
+			Condition:   one(),
+			Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+		}, "if2 2else 2"},
+		{&ReturnStatement{ReturnValue: one()}, " 2;"},
+		{&LetStatement{Name: &Identifier{Value: "x"}, Value: one()}, " x = 2;"},
+		{&FunctionLiteral{Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}}, "()2"},
+		{&ArrayLiteral{Elements: []Expression{one(), one()}}, "[2, 2]"},
+		{&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{one()}}, "f(2)"},
+		{&MatchExpression{Subject: one(), Arms: []*MatchArm{{Pattern: one(), Body: &BlockStatement{}}}}, "match 2 {2 => }"},
+	}
+
+	for _, tt := range tests {
+		before := tt.input.String()
+		modified := Modify(tt.input, turnOneIntoTwo)
+		if actual := modified.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+		if after := tt.input.String(); after != before {
+			t.Errorf("Modify changed its input: %q became %q", before, after)
+		}
+	}
+
+	hash := &HashLiteral{Pairs: map[Expression]Expression{one(): one()}}
+	for k, v := range Modify(hash, turnOneIntoTwo).(*HashLiteral).Pairs {
+		if k.(*IntegerLiteral).Value != 2 || v.(*IntegerLiteral).Value != 2 {
+			t.Errorf("hash pair not modified: %s: %s", k, v)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package ast
+
+// ModifierFunc is applied to every node Modify visits. It returns the node to
+// put in its place, which may be the node itself
+type ModifierFunc func(Node) Node
+
+// Modify walks the tree rooted at node depth first, replacing each node w/
+// what modifier returns for it. Children are modified before their parent,
+// so the modifier sees the parent w/ its new children in place. The tree
+// passed in is left as it was: every node w/ children is copied, so the same
+// tree (say, a macro body) can be modified over and over. Replacements of the
+// wrong kind for their slot (an expression where a statement goes, ...) are
+// dropped and the original is kept
+func Modify(node Node, modifier ModifierFunc) Node {
+	var modified Node = node
+
+	switch node := node.(type) {
+	case *Program:
+		c := *node
+		c.Statements = modifyStatements(c.Statements, modifier)
+		modified = &c
+
+	case *BlockStatement:
+		if node == nil {
+			return node
+		}
+		c := *node
+		c.Statements = modifyStatements(c.Statements, modifier)
+		modified = &c
+
+	case *ExpressionStatement:
+		c := *node
+		c.Expression = modifyExpression(c.Expression, modifier)
+		modified = &c
+
+	case *LetStatement:
+		c := *node
+		c.Value = modifyExpression(c.Value, modifier)
+		modified = &c
+
+	case *ReturnStatement:
+		c := *node
+		c.ReturnValue = modifyExpression(c.ReturnValue, modifier)
+		modified = &c
+
+	case *ThrowStatement:
+		c := *node
+		c.Value = modifyExpression(c.Value, modifier)
+		modified = &c
+
+	case *DeferStatement:
+		c := *node
+		c.Call = modifyCall(c.Call, modifier)
+		modified = &c
+
+	case *FunctionStatement:
+		c := *node
+		c.Function = modifyFunction(c.Function, modifier)
+		modified = &c
+
+	case *ImplStatement:
+		c := *node
+		c.Methods = make([]*MethodDefinition, len(node.Methods))
+		for ix, m := range node.Methods {
+			c.Methods[ix] = &MethodDefinition{Name: m.Name, Function: modifyFunction(m.Function, modifier)}
+		}
+		modified = &c
+
+	case *InfixStatement:
+		c := *node
+		c.Function = modifyExpression(c.Function, modifier)
+		modified = &c
+
+	case *ExportStatement:
+		c := *node
+		if stmt, ok := Modify(c.Statement, modifier).(Statement); ok {
+			c.Statement = stmt
+		}
+		modified = &c
+
+	case *PrefixExpression:
+		c := *node
+		c.Right = modifyExpression(c.Right, modifier)
+		modified = &c
+
+	case *InfixExpression:
+		c := *node
+		c.Left = modifyExpression(c.Left, modifier)
+		c.Right = modifyExpression(c.Right, modifier)
+		modified = &c
+
+	case *IndexExpression:
+		c := *node
+		c.Left = modifyExpression(c.Left, modifier)
+		c.Index = modifyExpression(c.Index, modifier)
+		modified = &c
+
+	case *IfExpression:
+		c := *node
+		c.Condition = modifyExpression(c.Condition, modifier)
+		c.Consequence = modifyBlock(c.Consequence, modifier)
+		c.Alternative = modifyBlock(c.Alternative, modifier)
+		modified = &c
+
+	case *FunctionLiteral:
+		c := *node
+		c.Body = modifyBlock(c.Body, modifier)
+		modified = &c
+
+	case *CallExpression:
+		c := *node
+		c.Function = modifyExpression(c.Function, modifier)
+		c.Arguments = modifyExpressions(c.Arguments, modifier)
+		modified = &c
+
+	case *ArrayLiteral:
+		c := *node
+		c.Elements = modifyExpressions(c.Elements, modifier)
+		modified = &c
+
+	case *InterpolatedString:
+		c := *node
+		c.Parts = modifyExpressions(c.Parts, modifier)
+		modified = &c
+
+	case *HashLiteral:
+		c := *node
+		c.Pairs = make(map[Expression]Expression, len(node.Pairs))
+		for k, v := range node.Pairs {
+			c.Pairs[modifyExpression(k, modifier)] = modifyExpression(v, modifier)
+		}
+		modified = &c
+
+	case *MatchExpression:
+		c := *node
+		c.Subject = modifyExpression(c.Subject, modifier)
+		c.Arms = make([]*MatchArm, len(node.Arms))
+		for ix, arm := range node.Arms {
+			c.Arms[ix] = &MatchArm{
+				Pattern: modifyExpression(arm.Pattern, modifier),
+				Body:    modifyBlock(arm.Body, modifier),
+			}
+		}
+		modified = &c
+
+	case *MemberExpression:
+		c := *node
+		c.Object = modifyExpression(c.Object, modifier)
+		modified = &c
+
+	case *AssignExpression:
+		c := *node
+		c.Target = modifyExpression(c.Target, modifier)
+		c.Value = modifyExpression(c.Value, modifier)
+		modified = &c
+
+	case *PropagateExpression:
+		c := *node
+		c.Left = modifyExpression(c.Left, modifier)
+		modified = &c
+
+	case *TryExpression:
+		c := *node
+		c.Block = modifyBlock(c.Block, modifier)
+		c.Catch = modifyBlock(c.Catch, modifier)
+		c.Finally = modifyBlock(c.Finally, modifier)
+		modified = &c
+
+	case *YieldExpression:
+		c := *node
+		c.Value = modifyExpression(c.Value, modifier)
+		modified = &c
+
+	case *ForExpression:
+		c := *node
+		c.Iterable = modifyExpression(c.Iterable, modifier)
+		c.Body = modifyBlock(c.Body, modifier)
+		modified = &c
+
+	case *SpawnExpression:
+		c := *node
+		c.Call = modifyCall(c.Call, modifier)
+		modified = &c
+
+	case *AwaitExpression:
+		c := *node
+		c.Value = modifyExpression(c.Value, modifier)
+		modified = &c
+
+	case *SelectExpression:
+		c := *node
+		c.Cases = make([]*SelectCase, len(node.Cases))
+		for ix, sc := range node.Cases {
+			scc := *sc
+			scc.Channel = modifyExpression(sc.Channel, modifier)
+			scc.Value = modifyExpression(sc.Value, modifier)
+			scc.Body = modifyBlock(sc.Body, modifier)
+			c.Cases[ix] = &scc
+		}
+		c.Default = modifyBlock(c.Default, modifier)
+		modified = &c
+	}
+
+	return modifier(modified)
+}
+
+func modifyStatements(stmts []Statement, modifier ModifierFunc) []Statement {
+	modified := make([]Statement, len(stmts))
+	for ix, stmt := range stmts {
+		modified[ix] = stmt
+		if stmt, ok := Modify(stmt, modifier).(Statement); ok {
+			modified[ix] = stmt
+		}
+	}
+	return modified
+}
+
+func modifyExpression(exp Expression, modifier ModifierFunc) Expression {
+	if exp == nil {
+		return nil
+	}
+	if modified, ok := Modify(exp, modifier).(Expression); ok {
+		return modified
+	}
+	return exp
+}
+
+func modifyExpressions(exps []Expression, modifier ModifierFunc) []Expression {
+	modified := make([]Expression, len(exps))
+	for ix, exp := range exps {
+		modified[ix] = modifyExpression(exp, modifier)
+	}
+	return modified
+}
+
+func modifyBlock(block *BlockStatement, modifier ModifierFunc) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	if modified, ok := Modify(block, modifier).(*BlockStatement); ok {
+		return modified
+	}
+	return block
+}
+
+func modifyCall(call *CallExpression, modifier ModifierFunc) *CallExpression {
+	if modified, ok := Modify(call, modifier).(*CallExpression); ok {
+		return modified
+	}
+	return call
+}
+
+func modifyFunction(fl *FunctionLiteral, modifier ModifierFunc) *FunctionLiteral {
+	if modified, ok := Modify(fl, modifier).(*FunctionLiteral); ok {
+		return modified
+	}
+	return fl
+}
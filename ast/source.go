@@ -0,0 +1,329 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source prints node as source code that parses back to the same tree, up to
+// positions. Unlike String, which is for debugging, it keeps string quotes
+// and block braces. Infix and prefix expressions are always parenthesized,
+// other compound expressions only where an operator would otherwise take
+// them apart, and hash pairs print in order of their printed keys. A string
+// w/ a double quote in it has no source form, and neither does text ending
+// in a backslash right before an interpolation; those make Source fail.
+// Nodes added by parser extensions print as their String
+func Source(node Node) (string, error) {
+	p := &sourcePrinter{}
+	if program, ok := node.(*Program); ok {
+		return strings.Join(p.statements(program.Statements), "\n"), p.err
+	}
+	return p.node(node), p.err
+}
+
+type sourcePrinter struct {
+	depth int // of the block being printed
+	err   error
+}
+
+func (p *sourcePrinter) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+}
+
+// statements prints one statement per line at the current depth. Anything
+// that could run on into the next statement gets a semicolon
+func (p *sourcePrinter) statements(stmts []Statement) []string {
+	indent := strings.Repeat("\t", p.depth)
+	lines := []string{}
+	for ix, stmt := range stmts {
+		line := indent + p.node(stmt)
+		if ix < len(stmts)-1 && !isDeclaration(stmt) {
+			line += ";"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func isDeclaration(stmt Statement) bool {
+	if export, ok := stmt.(*ExportStatement); ok {
+		stmt = export.Statement
+	}
+	switch stmt.(type) {
+	case *FunctionStatement, *StructStatement, *EnumStatement, *ImplStatement:
+		return true
+	}
+	return false
+}
+
+func (p *sourcePrinter) block(block *BlockStatement) string {
+	if block == nil {
+		p.fail("missing block")
+		return "{}"
+	}
+	if len(block.Statements) == 0 {
+		return "{}"
+	}
+	p.depth++
+	lines := p.statements(block.Statements)
+	p.depth--
+	return "{\n" + strings.Join(lines, "\n") + "\n" + strings.Repeat("\t", p.depth) + "}"
+}
+
+// arms prints the arms of a match or select, one per line
+func (p *sourcePrinter) arms(arms []string) string {
+	if len(arms) == 0 {
+		return "{}"
+	}
+	indent := strings.Repeat("\t", p.depth)
+	return "{\n" + indent + "\t" + strings.Join(arms, ",\n"+indent+"\t") + "\n" + indent + "}"
+}
+
+func (p *sourcePrinter) expressions(exprs []Expression) string {
+	out := []string{}
+	for _, expr := range exprs {
+		out = append(out, p.node(expr))
+	}
+	return strings.Join(out, ", ")
+}
+
+func identifierNames(idents []*Identifier) string {
+	out := []string{}
+	for _, ident := range idents {
+		out = append(out, ident.Value)
+	}
+	return strings.Join(out, ", ")
+}
+
+// operand prints expr where an operator applies to it, parenthesized unless
+// it already holds together, e.g. the yield in `(yield x) + 1`
+func (p *sourcePrinter) operand(expr Expression) string {
+	switch expr.(type) {
+	case *Identifier, *IntegerLiteral, *Boolean, *StringLiteral, *InterpolatedString, *NullLiteral,
+		*SymbolLiteral, *ArrayLiteral, *HashLiteral, *CallExpression, *IndexExpression,
+		*MemberExpression, *PropagateExpression, *InfixExpression, *PrefixExpression:
+		return p.node(expr)
+	}
+	return "(" + p.node(expr) + ")"
+}
+
+// stringText escapes text for the inside of a string literal. A `$` is
+// escaped where it would start an interpolation or be taken for part of a
+// `\$` escape. interpolated says whether an interpolation follows
+func (p *sourcePrinter) stringText(text string, interpolated bool) string {
+	if strings.Contains(text, `"`) {
+		p.fail("no source form for a string containing a double quote: %s", text)
+	}
+	if interpolated && strings.HasSuffix(text, `\`) {
+		p.fail("no source form for text ending in a backslash before an interpolation: %s", text)
+	}
+	var out strings.Builder
+	for ix := 0; ix < len(text); ix++ {
+		if text[ix] == '$' && (ix+1 < len(text) && text[ix+1] == '{' || ix > 0 && text[ix-1] == '\\') {
+			out.WriteByte('\\')
+		}
+		out.WriteByte(text[ix])
+	}
+	return out.String()
+}
+
+func (p *sourcePrinter) function(fl *FunctionLiteral) string {
+	out := "fn"
+	if fl.Generator {
+		out += "*"
+	}
+	if fl.Name != "" {
+		out += " " + fl.Name
+	}
+	return out + fl.signature() + " " + p.block(fl.Body)
+}
+
+func (p *sourcePrinter) node(node Node) string {
+	if v := reflect.ValueOf(node); node == nil || v.Kind() == reflect.Ptr && v.IsNil() {
+		p.fail("missing node")
+		return "?"
+	}
+	switch node := node.(type) {
+	case *ExpressionStatement:
+		if fl, ok := node.Expression.(*FunctionLiteral); ok && fl.Name != "" {
+			// `fn name() {}` on its own would be a declaration
+			return "(" + p.node(fl) + ")"
+		}
+		return p.node(node.Expression)
+	case *LetStatement:
+		keyword := "let "
+		if node.Const {
+			keyword = "const "
+		}
+		name := node.Name.Value
+		if node.Type != nil {
+			name += ": " + node.Type.String()
+		}
+		return keyword + name + " = " + p.node(node.Value)
+	case *ReturnStatement:
+		return "return " + p.node(node.ReturnValue)
+	case *ThrowStatement:
+		return "throw " + p.node(node.Value)
+	case *DeferStatement:
+		return "defer " + p.node(node.Call)
+	case *FunctionStatement:
+		return p.function(node.Function)
+	case *StructStatement:
+		return "struct " + node.Name.Value + " {" + identifierNames(node.Fields) + "}"
+	case *EnumStatement:
+		variants := []string{}
+		for _, v := range node.Variants {
+			if v.Fields == nil {
+				variants = append(variants, v.Name.Value)
+			} else {
+				variants = append(variants, v.Name.Value+"("+identifierNames(v.Fields)+")")
+			}
+		}
+		return "enum " + node.Name.Value + " {" + strings.Join(variants, ", ") + "}"
+	case *ImplStatement:
+		if len(node.Methods) == 0 {
+			return "impl " + node.Name.Value + " {}"
+		}
+		p.depth++
+		indent := strings.Repeat("\t", p.depth)
+		methods := []string{}
+		for _, m := range node.Methods {
+			methods = append(methods, indent+"fn "+m.Name.Value+m.Function.signature()+" "+p.block(m.Function.Body))
+		}
+		p.depth--
+		return "impl " + node.Name.Value + " {\n" + strings.Join(methods, "\n") + "\n" + strings.Repeat("\t", p.depth) + "}"
+	case *ImportStatement:
+		out := "import \"" + p.stringText(node.Path, false) + "\""
+		if node.Alias != nil {
+			out += " as " + node.Alias.Value
+		}
+		return out
+	case *ExportStatement:
+		return "export " + p.node(node.Statement)
+	case *InfixStatement:
+		return "infix " + node.Operator + " (precedence: " + node.Precedence + ", assoc: " + node.Assoc +
+			") = " + p.node(node.Function)
+	case *BlockStatement:
+		return p.block(node)
+
+	case *Identifier:
+		return node.Value
+	case *IntegerLiteral:
+		return strconv.FormatInt(node.Value, 10)
+	case *Boolean:
+		return strconv.FormatBool(node.Value)
+	case *StringLiteral:
+		return "\"" + p.stringText(node.Value, false) + "\""
+	case *InterpolatedString:
+		var out strings.Builder
+		out.WriteString("\"")
+		for ix, part := range node.Parts {
+			if ix%2 == 1 {
+				out.WriteString("${" + p.node(part) + "}")
+				continue
+			}
+			text, ok := part.(*StringLiteral)
+			if !ok {
+				p.fail("interpolated string text must be a StringLiteral, got %T", part)
+				continue
+			}
+			out.WriteString(p.stringText(text.Value, ix < len(node.Parts)-1))
+		}
+		out.WriteString("\"")
+		return out.String()
+	case *NullLiteral:
+		return "null"
+	case *SymbolLiteral:
+		return ":" + node.Value
+	case *PrefixExpression:
+		return "(" + node.Operator + p.operand(node.Right) + ")"
+	case *InfixExpression:
+		return "(" + p.operand(node.Left) + " " + node.Operator + " " + p.operand(node.Right) + ")"
+	case *AssignExpression:
+		return p.node(node.Target) + " = " + p.node(node.Value)
+	case *IndexExpression:
+		return p.operand(node.Left) + "[" + p.node(node.Index) + "]"
+	case *MemberExpression:
+		return p.operand(node.Object) + "." + node.Property.Value
+	case *PropagateExpression:
+		return p.operand(node.Left) + "?"
+	case *CallExpression:
+		return p.operand(node.Function) + "(" + p.expressions(node.Arguments) + ")"
+	case *ArrayLiteral:
+		return "[" + p.expressions(node.Elements) + "]"
+	case *HashLiteral:
+		pairs := [][2]string{}
+		for k, v := range node.Pairs {
+			pairs = append(pairs, [2]string{p.node(k), p.node(v)})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+		items := []string{}
+		for _, pair := range pairs {
+			items = append(items, pair[0]+": "+pair[1])
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case *FunctionLiteral:
+		return p.function(node)
+	case *MacroLiteral:
+		return "macro(" + identifierNames(node.Parameters) + ") " + p.block(node.Body)
+	case *IfExpression:
+		out := "if (" + p.node(node.Condition) + ") " + p.block(node.Consequence)
+		if node.Alternative != nil {
+			out += " else " + p.block(node.Alternative)
+		}
+		return out
+	case *MatchExpression:
+		p.depth++
+		arms := []string{}
+		for _, arm := range node.Arms {
+			arms = append(arms, p.node(arm.Pattern)+" => "+p.block(arm.Body))
+		}
+		p.depth--
+		return "match (" + p.node(node.Subject) + ") " + p.arms(arms)
+	case *TryExpression:
+		out := "try " + p.block(node.Block)
+		if node.Catch != nil {
+			out += " catch "
+			if node.CatchParam != nil {
+				out += "(" + node.CatchParam.Value + ") "
+			}
+			out += p.block(node.Catch)
+		}
+		if node.Finally != nil {
+			out += " finally " + p.block(node.Finally)
+		}
+		return out
+	case *ForExpression:
+		return "for (" + node.Variable.Value + " in " + p.node(node.Iterable) + ") " + p.block(node.Body)
+	case *YieldExpression:
+		return "yield " + p.node(node.Value)
+	case *AwaitExpression:
+		return "await " + p.operand(node.Value)
+	case *SpawnExpression:
+		return "spawn " + p.node(node.Call)
+	case *SelectExpression:
+		p.depth++
+		cases := []string{}
+		for _, sc := range node.Cases {
+			op := "recv(" + p.node(sc.Channel) + ")"
+			if sc.Value != nil {
+				op = "send(" + p.node(sc.Channel) + ", " + p.node(sc.Value) + ")"
+			}
+			if sc.Binding != nil {
+				op = sc.Binding.Value + " = " + op
+			}
+			cases = append(cases, op+" => "+p.block(sc.Body))
+		}
+		if node.Default != nil {
+			cases = append(cases, "_ => "+p.block(node.Default))
+		}
+		p.depth--
+		return "select " + p.arms(cases)
+	}
+	return node.String()
+}
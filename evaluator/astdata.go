@@ -0,0 +1,443 @@
+package evaluator
+
+import (
+	"lang/ast"
+	"lang/lexer"
+	"lang/object"
+	"lang/parser"
+	"lang/token"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// ASTs are handed to scripts as plain data: every node is a hash w/ its Go
+// type name under "node", its token's literal, line and column under
+// "token", "line" and "column", and each field under its snake_cased name.
+// Child nodes are nested hashes, lists of them arrays, and absent children
+// null. The pairs of a hash literal are an array of [key, value] arrays
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []interface{}{
+		ast.Program{}, ast.LetStatement{}, ast.ReturnStatement{}, ast.ExpressionStatement{},
+		ast.BlockStatement{}, ast.FunctionStatement{}, ast.ThrowStatement{}, ast.DeferStatement{},
+		ast.StructStatement{}, ast.ImplStatement{}, ast.EnumStatement{}, ast.ImportStatement{},
+		ast.ExportStatement{}, ast.InfixStatement{}, ast.Identifier{}, ast.IntegerLiteral{}, ast.Boolean{},
+		ast.StringLiteral{}, ast.InterpolatedString{}, ast.NullLiteral{}, ast.SymbolLiteral{}, ast.ArrayLiteral{},
+		ast.HashLiteral{}, ast.FunctionLiteral{}, ast.MacroLiteral{}, ast.PrefixExpression{},
+		ast.InfixExpression{}, ast.IfExpression{}, ast.CallExpression{}, ast.IndexExpression{},
+		ast.MemberExpression{}, ast.AssignExpression{}, ast.PropagateExpression{},
+		ast.MatchExpression{}, ast.TryExpression{}, ast.YieldExpression{}, ast.ForExpression{},
+		ast.SpawnExpression{}, ast.AwaitExpression{}, ast.SelectExpression{}, ast.MatchArm{},
+		ast.SelectCase{}, ast.EnumVariant{}, ast.MethodDefinition{}, ast.TypeExpr{},
+	} {
+		t := reflect.TypeOf(node)
+		nodeTypes[t.Name()] = t
+	}
+
+	builtins["parse"] = &object.Builtin{Fn: parseBuiltin}
+	builtins["unparse"] = &object.Builtin{Fn: unparseBuiltin}
+	builtins["eval"] = &object.Builtin{FrameFn: evalBuiltin}
+}
+
+var (
+	tokenType  = reflect.TypeOf(token.Token{})
+	astPackage = reflect.TypeOf(ast.Program{}).PkgPath()
+)
+
+// fieldKey is the key a node field is stored under, e.g. ReturnValue ->
+// return_value
+func fieldKey(name string) string {
+	var out strings.Builder
+	for ix, r := range name {
+		if unicode.IsUpper(r) {
+			if ix > 0 {
+				out.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+func setPair(hash *object.Hash, key string, val object.Object) {
+	k := &object.String{Value: key}
+	hash.Pairs[k.HashKey()] = object.HashPair{Key: k, Value: val}
+}
+
+func getPair(hash *object.Hash, key string) (object.Object, bool) {
+	pair, ok := hash.Pairs[(&object.String{Value: key}).HashKey()]
+	return pair.Value, ok
+}
+
+// NodeToObject converts an AST into its data representation
+func NodeToObject(node ast.Node) object.Object {
+	return valueToObject(reflect.ValueOf(node))
+}
+
+func valueToObject(v reflect.Value) object.Object {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return NULL
+		}
+		return valueToObject(v.Elem())
+	case reflect.Struct:
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		setPair(hash, "node", &object.String{Value: v.Type().Name()})
+		for ix := 0; ix < v.NumField(); ix++ {
+			field := v.Type().Field(ix)
+			if field.Anonymous {
+				continue
+			}
+			if field.Type == tokenType {
+				tok := v.Field(ix).Interface().(token.Token)
+				setPair(hash, "token", &object.String{Value: tok.Literal})
+				setPair(hash, "line", &object.Integer{Value: int64(tok.Line)})
+				setPair(hash, "column", &object.Integer{Value: int64(tok.Column)})
+				continue
+			}
+			setPair(hash, fieldKey(field.Name), valueToObject(v.Field(ix)))
+		}
+		return hash
+	case reflect.Slice:
+		elements := make([]object.Object, v.Len())
+		for ix := range elements {
+			elements[ix] = valueToObject(v.Index(ix))
+		}
+		return &object.Array{Elements: elements}
+	case reflect.Map:
+		pairs := []object.Object{}
+		iter := v.MapRange()
+		for iter.Next() {
+			pairs = append(pairs, &object.Array{Elements: []object.Object{
+				valueToObject(iter.Key()), valueToObject(iter.Value()),
+			}})
+		}
+		return &object.Array{Elements: pairs}
+	case reflect.String:
+		return &object.String{Value: v.String()}
+	case reflect.Int64:
+		return &object.Integer{Value: v.Int()}
+	case reflect.Bool:
+		return getGlobalBool(v.Bool())
+	}
+	return NULL
+}
+
+// ObjectToNode converts the data representation of an AST, as built by
+// NodeToObject, back into the AST. Quotes are accepted wherever a node is
+func ObjectToNode(obj object.Object) (ast.Node, *object.Error) {
+	v, err := objectToValue(obj, reflect.TypeOf((*ast.Node)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+	if v.IsNil() {
+		return nil, newError(object.TYPE_ERROR, "malformed AST: expected a node, got null")
+	}
+	return v.Interface().(ast.Node), nil
+}
+
+func objectToValue(obj object.Object, t reflect.Type) (reflect.Value, *object.Error) {
+	mismatch := func() (reflect.Value, *object.Error) {
+		return reflect.Value{}, newError(object.TYPE_ERROR, "malformed AST: expected %s, got %s", t, object.TypeName(obj))
+	}
+
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if obj == NULL {
+			return reflect.Zero(t), nil
+		}
+		var node reflect.Value
+		switch obj := obj.(type) {
+		case *object.Quote:
+			node = reflect.ValueOf(obj.Node)
+		case *object.Hash:
+			var err *object.Error
+			if node, err = hashToNode(obj); err != nil {
+				return node, err
+			}
+		default:
+			return mismatch()
+		}
+		if !node.Type().AssignableTo(t) {
+			return reflect.Value{}, newError(object.TYPE_ERROR, "malformed AST: expected %s, got %s", t, node.Type())
+		}
+		v := reflect.New(t).Elem()
+		v.Set(node)
+		return v, nil
+
+	case reflect.Slice:
+		array, ok := obj.(*object.Array)
+		if !ok {
+			return mismatch()
+		}
+		v := reflect.MakeSlice(t, len(array.Elements), len(array.Elements))
+		for ix, el := range array.Elements {
+			elem, err := objectToValue(el, t.Elem())
+			if err != nil {
+				return elem, err
+			}
+			v.Index(ix).Set(elem)
+		}
+		return v, nil
+
+	case reflect.Map:
+		array, ok := obj.(*object.Array)
+		if !ok {
+			return mismatch()
+		}
+		v := reflect.MakeMap(t)
+		for _, el := range array.Elements {
+			pair, ok := el.(*object.Array)
+			if !ok || len(pair.Elements) != 2 {
+				return reflect.Value{}, newError(object.TYPE_ERROR, "malformed AST: hash pairs must be [key, value] arrays")
+			}
+			k, err := objectToValue(pair.Elements[0], t.Key())
+			if err != nil {
+				return k, err
+			}
+			val, err := objectToValue(pair.Elements[1], t.Elem())
+			if err != nil {
+				return val, err
+			}
+			v.SetMapIndex(k, val)
+		}
+		return v, nil
+
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	case reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			return reflect.ValueOf(i.Value).Convert(t), nil
+		}
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			return reflect.ValueOf(b.Value), nil
+		}
+	}
+	return mismatch()
+}
+
+// optionalFields are the children of ast nodes that can be absent or null.
+// Every other child has to be there, since the evaluator and unparse rely on
+// it; lists can be empty but not hold nulls. Types added by parser
+// extensions aren't checked
+var optionalFields = map[string]bool{
+	"LetStatement.Type":          true,
+	"FunctionLiteral.ReturnType": true,
+	"FunctionLiteral.ParamTypes": true,
+	"IfExpression.Alternative":   true,
+	"TryExpression.CatchParam":   true,
+	"TryExpression.Catch":        true,
+	"TryExpression.Finally":      true,
+	"SelectExpression.Default":   true,
+	"SelectCase.Binding":         true,
+	"SelectCase.Value":           true,
+	"ImportStatement.Alias":      true,
+	"TypeExpr.Return":            true,
+}
+
+// checkRequired fails if v, the converted value of field of t, is or holds a
+// null where a node is required
+func checkRequired(t reflect.Type, field reflect.StructField, v reflect.Value) *object.Error {
+	if t.PkgPath() != astPackage || optionalFields[t.Name()+"."+field.Name] {
+		return nil
+	}
+	isNull := func(v reflect.Value) bool {
+		return (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return newError(object.TYPE_ERROR, "malformed AST: %s has no %s", t.Name(), fieldKey(field.Name))
+		}
+	case reflect.Slice:
+		for ix := 0; ix < v.Len(); ix++ {
+			if isNull(v.Index(ix)) {
+				return newError(object.TYPE_ERROR, "malformed AST: %s has a null in %s", t.Name(), fieldKey(field.Name))
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if isNull(iter.Key()) || isNull(iter.Value()) {
+				return newError(object.TYPE_ERROR, "malformed AST: %s has a null in %s", t.Name(), fieldKey(field.Name))
+			}
+		}
+	}
+	return nil
+}
+
+// hashToNode builds the node a hash describes, returning a pointer to it
+func hashToNode(hash *object.Hash) (reflect.Value, *object.Error) {
+	name, ok := getPair(hash, "node")
+	if !ok {
+		return reflect.Value{}, newError(object.TYPE_ERROR, "malformed AST: hash has no \"node\" key")
+	}
+	t, ok := nodeTypes[name.Inspect()]
+	if !ok {
+		return reflect.Value{}, newError(object.TYPE_ERROR, "malformed AST: unknown node type %s", name.Inspect())
+	}
+
+	node := reflect.New(t)
+	for ix := 0; ix < t.NumField(); ix++ {
+		field := t.Field(ix)
+		if field.Anonymous {
+			continue
+		}
+		if field.Type == tokenType {
+			node.Elem().Field(ix).Set(reflect.ValueOf(hashToken(hash)))
+			continue
+		}
+		v := reflect.Zero(field.Type)
+		if obj, ok := getPair(hash, fieldKey(field.Name)); ok {
+			var err *object.Error
+			if v, err = objectToValue(obj, field.Type); err != nil {
+				return v, err
+			}
+		}
+		if err := checkRequired(t, field, v); err != nil {
+			return v, err
+		}
+		node.Elem().Field(ix).Set(v)
+	}
+	return node, nil
+}
+
+func hashToken(hash *object.Hash) token.Token {
+	tok := token.Token{}
+	if literal, ok := getPair(hash, "token"); ok {
+		tok.Literal = literal.Inspect()
+		tok.Type = lexer.LookupIdentifierType(tok.Literal)
+	}
+	if line, ok := getPair(hash, "line"); ok {
+		if line, ok := line.(*object.Integer); ok {
+			tok.Line = int(line.Value)
+		}
+	}
+	if column, ok := getPair(hash, "column"); ok {
+		if column, ok := column.(*object.Integer); ok {
+			tok.Column = int(column.Value)
+		}
+	}
+	return tok
+}
+
+// parseSource parses src, failing w/ a SyntaxError listing the parser errors
+func parseSource(src string) (*ast.Program, *object.Error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, newError(object.SYNTAX_ERROR, "%s", strings.Join(p.Errors(), "; "))
+	}
+	return program, nil
+}
+
+// parseBuiltin implements `parse(src)`, returning the program's AST as data
+func parseBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	src, ok := args[0].(*object.String)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `parse` must be STRING, got %s", object.TypeName(args[0]))
+	}
+	program, err := parseSource(src.Value)
+	if err != nil {
+		return err
+	}
+	return NodeToObject(program)
+}
+
+// unparseBuiltin implements `unparse(ast)`, turning an AST, as data or a
+// quote, back into source that parses to the same AST
+func unparseBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	node, err := ObjectToNode(args[0])
+	if err != nil {
+		return err
+	}
+	src, srcErr := ast.Source(node)
+	if srcErr != nil {
+		return newError(object.TYPE_ERROR, "cannot unparse: %s", srcErr)
+	}
+	return &object.String{Value: src}
+}
+
+// callerOptions are the options of the code that made the call frame is
+// for, or made the builtin call that led to it
+func callerOptions(frame *object.Frame) object.Options {
+	for f := frame; f != nil; f = f.Parent {
+		if f.Env != nil {
+			return f.Env.Options()
+		}
+	}
+	return object.Options{}
+}
+
+// evalBuiltin implements `eval(src_or_ast)` and `eval(src_or_ast, bindings)`.
+// The code runs in a fresh environment holding only bindings, a hash of
+// STRING names to values, if given. There's no way to hand it the caller's
+// environment: it can't see the caller's variables, and assigning to a
+// binding rebinds the name in that fresh environment only, so nothing flows
+// back to the caller except the result. It does keep the caller's Options,
+// so e.g. the call depth limit still applies
+func evalBuiltin(frame *object.Frame, args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+
+	var program *ast.Program
+	if src, ok := args[0].(*object.String); ok {
+		var err *object.Error
+		if program, err = parseSource(src.Value); err != nil {
+			return err
+		}
+	} else {
+		node, err := ObjectToNode(args[0])
+		if err != nil {
+			return err
+		}
+		switch node := node.(type) {
+		case *ast.Program:
+			program = node
+		case ast.Statement:
+			program = &ast.Program{Statements: []ast.Statement{node}}
+		case ast.Expression:
+			program = &ast.Program{Statements: []ast.Statement{&ast.ExpressionStatement{Expression: node}}}
+		default:
+			return newError(object.TYPE_ERROR, "cannot eval a %s", reflect.TypeOf(node).Elem().Name())
+		}
+	}
+
+	env := object.NewCallEnvironment(nil, frame)
+	env.SetOptions(callerOptions(frame))
+	if len(args) == 2 {
+		bindings, ok := args[1].(*object.Hash)
+		if !ok {
+			return newError(object.TYPE_ERROR, "bindings for `eval` must be HASH, got %s", object.TypeName(args[1]))
+		}
+		for _, pair := range bindings.Pairs {
+			name, ok := pair.Key.(*object.String)
+			if !ok {
+				return newError(object.TYPE_ERROR, "binding names must be STRING, got %s", object.TypeName(pair.Key))
+			}
+			env.Set(name.Value, pair.Value)
+		}
+	}
+
+	macroEnv := object.NewEnvironment()
+	DefineMacros(program, macroEnv)
+	program, err := ExpandMacros(program, macroEnv)
+	if err != nil {
+		return err
+	}
+	return Eval(program, env)
+}
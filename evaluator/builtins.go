@@ -9,7 +9,7 @@ var builtins = map[string]*object.Builtin{
 	"len": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 			switch arg := args[0].(type) {
 			case *object.Array:
@@ -17,35 +17,35 @@ var builtins = map[string]*object.Builtin{
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
 			default:
-				return newError("argument to `len` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `len` not supported, got %s", object.TypeName(args[0]))
 			}
 		},
 	},
 	"first": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 			switch arg := args[0].(type) {
 			case *object.Array:
-				if len(arg.Elements) > 0 {
-					return NULL
+				if len(arg.Elements) == 0 {
+					return newError(object.INDEX_ERROR, "first of empty ARRAY")
 				}
 				return arg.Elements[0]
 			case *object.String:
-				if len(arg.Value) > 0 {
-					return NULL
+				if len(arg.Value) == 0 {
+					return newError(object.INDEX_ERROR, "first of empty STRING")
 				}
 				return &object.String{Value: string(arg.Value[0])}
 			default:
-				return newError("argument to `first` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `first` not supported, got %s", object.TypeName(args[0]))
 			}
 		},
 	},
 	"rest": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			switch arg := args[0].(type) {
@@ -67,7 +67,7 @@ var builtins = map[string]*object.Builtin{
 				}
 				return &object.String{Value: arg.Value[1:]}
 			default:
-				return newError("argument to `rest` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `rest` not supported, got %s", object.TypeName(args[0]))
 			}
 
 		},
@@ -75,18 +75,62 @@ var builtins = map[string]*object.Builtin{
 	"push": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2", len(args))
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
 			}
 
 			arr, ok := args[0].(*object.Array)
 			if !ok {
-				return newError("argument to `push` not supported, got %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "argument to `push` not supported, got %s", object.TypeName(args[0]))
 
 			}
-			return &object.Array{Elements: append(arr.Elements, args[1])}
+			// Copy rather than append in place: arrays are shared (between
+			// bindings, and between tasks) on the understanding they never change
+			elements := make([]object.Object, len(arr.Elements), len(arr.Elements)+1)
+			copy(elements, arr.Elements)
+			return &object.Array{Elements: append(elements, args[1])}
 
 		},
 	},
+	"error": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			kind, ok := args[0].(*object.String)
+			if !ok {
+				return newError(object.TYPE_ERROR, "argument to `error` not supported, got %s", object.TypeName(args[0]))
+			}
+			msg, ok := args[1].(*object.String)
+			if !ok {
+				return newError(object.TYPE_ERROR, "argument to `error` not supported, got %s", object.TypeName(args[1]))
+			}
+			err := &object.Error{Kind: kind.Value, Message: msg.Value}
+			if len(args) == 3 {
+				err.Payload = args[2]
+			}
+			return &object.Exception{Err: err}
+		},
+	},
+	"help": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *object.Function:
+				return &object.String{Value: arg.Signature()}
+			case *object.BoundMethod:
+				if fn, ok := arg.Method.(*object.Function); ok {
+					return &object.String{Value: fn.Signature()}
+				}
+				return &object.String{Value: "builtin method " + arg.Name}
+			case *object.Builtin:
+				return &object.String{Value: "builtin function"}
+			default:
+				return newError(object.TYPE_ERROR, "argument to `help` not supported, got %s", object.TypeName(args[0]))
+			}
+		},
+	},
 	"puts": {
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
@@ -95,4 +139,12 @@ var builtins = map[string]*object.Builtin{
 			return NULL
 		},
 	},
+	"str": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return &object.String{Value: args[0].Inspect()}
+		},
+	},
 }
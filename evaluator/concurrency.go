@@ -0,0 +1,183 @@
+package evaluator
+
+import (
+	"lang/ast"
+	"lang/object"
+	"reflect"
+)
+
+// evalSpawnExpression starts a call on a new goroutine and returns its task
+// right away. The callee and arguments are evaluated before the task starts
+func evalSpawnExpression(node *ast.SpawnExpression, env *object.Environment) object.Object {
+	function := Eval(node.Call.Function, env)
+	if isAbrupt(function) {
+		return function
+	}
+	args := evalExpressions(node.Call.Arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return args[0]
+	}
+
+	tok := node.Call.Token
+	frame := object.NewFrame(env.Frame(), tok.File, tok.Line, tok.Column)
+	frame.Function, frame.Env = calleeName(node.Call.Function), env
+	task := object.NewTask(frame.Function)
+
+	go func() {
+		var result object.Object
+		defer func() {
+			// Nothing up the goroutine's stack would recover a panic, and
+			// it would take the whole host down
+			if r := recover(); r != nil {
+				result = newError(object.INTERNAL_ERROR, "internal error in task: %v", r)
+			}
+			task.Finish(result)
+		}()
+		result = locate(applyFunction(function, args, frame), tok, env)
+	}()
+	return task
+}
+
+// evalAwait waits for a task to finish. An error that ended the task is
+// raised again in the awaiting one
+func evalAwait(val object.Object) object.Object {
+	task, ok := val.(*object.Task)
+	if !ok {
+		return newError(object.TYPE_ERROR, "cannot await %s", object.TypeName(val))
+	}
+	return task.Wait()
+}
+
+func evalSelectExpression(node *ast.SelectExpression, env *object.Environment) object.Object {
+	cases := make([]reflect.SelectCase, 0, len(node.Cases)+1)
+	for _, c := range node.Cases {
+		val := Eval(c.Channel, env)
+		if isAbrupt(val) {
+			return val
+		}
+		ch, ok := val.(*object.Channel)
+		if !ok {
+			return newError(object.TYPE_ERROR, "argument to `%s` not supported, got %s", c.Token.Literal, object.TypeName(val))
+		}
+
+		sc := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.C)}
+		if c.Value != nil {
+			send := Eval(c.Value, env)
+			if isAbrupt(send) {
+				return send
+			}
+			sc.Dir = reflect.SelectSend
+			sc.Send = reflect.ValueOf(&send).Elem()
+		}
+		cases = append(cases, sc)
+	}
+	if node.Default != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+
+	chosen, recv, recvOK, err := selectChannels(cases)
+	if err != nil {
+		return err
+	}
+	if chosen == len(node.Cases) {
+		return evalBlockStatements(node.Default, blockEnv(env))
+	}
+
+	c := node.Cases[chosen]
+	caseEnv := object.NewEnclosedEnvironment(env)
+	if c.Binding != nil {
+		var received object.Object = NONE
+		if recvOK {
+			received = newSome(recv.Interface().(object.Object))
+		}
+		caseEnv.Set(c.Binding.Value, received)
+	}
+	return evalBlockStatements(c.Body, caseEnv)
+}
+
+// selectChannels is reflect.Select, except a send on a closed channel is an
+// error rather than a panic
+func selectChannels(cases []reflect.SelectCase) (chosen int, recv reflect.Value, recvOK bool, err object.Object) {
+	defer func() {
+		if recover() != nil {
+			err = newError(object.ERROR, "%s", object.ErrClosedChannel)
+		}
+	}()
+	chosen, recv, recvOK = reflect.Select(cases)
+	return chosen, recv, recvOK, nil
+}
+
+func init() {
+	builtins["channel"] = &object.Builtin{Fn: newChannel}
+	builtins["send"] = &object.Builtin{Fn: channelSend}
+	builtins["recv"] = &object.Builtin{Fn: channelRecv}
+	builtins["close"] = &object.Builtin{Fn: channelClose}
+
+	RegisterMethod(object.CHANNEL_OBJ, "send", channelSend)
+	RegisterMethod(object.CHANNEL_OBJ, "recv", channelRecv)
+	RegisterMethod(object.CHANNEL_OBJ, "close", channelClose)
+}
+
+// newChannel implements `channel()` and `channel(size)`; without a size the
+// channel is unbuffered
+func newChannel(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=0 or 1", len(args))
+	}
+	size := int64(0)
+	if len(args) == 1 {
+		n, ok := args[0].(*object.Integer)
+		if !ok || n.Value < 0 {
+			return newError(object.TYPE_ERROR, "argument to `channel` not supported, got %s", args[0].Inspect())
+		}
+		size = n.Value
+	}
+	return object.NewChannel(int(size))
+}
+
+func channelArg(name string, args []object.Object, want int) (*object.Channel, object.Object) {
+	if len(args) != want {
+		return nil, newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d", len(args), want)
+	}
+	ch, ok := args[0].(*object.Channel)
+	if !ok {
+		return nil, newError(object.TYPE_ERROR, "argument to `%s` not supported, got %s", name, object.TypeName(args[0]))
+	}
+	return ch, nil
+}
+
+func channelSend(args ...object.Object) object.Object {
+	ch, err := channelArg("send", args, 2)
+	if err != nil {
+		return err
+	}
+	if err := ch.Send(args[1]); err != nil {
+		return newError(object.ERROR, "%s", err)
+	}
+	return NULL
+}
+
+// channelRecv returns Some(value), or None once the channel is closed and
+// drained
+func channelRecv(args ...object.Object) object.Object {
+	ch, err := channelArg("recv", args, 1)
+	if err != nil {
+		return err
+	}
+	val, ok := ch.Recv()
+	if !ok {
+		return NONE
+	}
+	return newSome(val)
+}
+
+func channelClose(args ...object.Object) object.Object {
+	ch, err := channelArg("close", args, 1)
+	if err != nil {
+		return err
+	}
+	if err := ch.Close(); err != nil {
+		return newError(object.ERROR, "%s", err)
+	}
+	return NULL
+}
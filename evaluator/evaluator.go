@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"lang/ast"
 	"lang/object"
+	"lang/token"
+	"reflect"
+	"runtime/debug"
+	"strings"
 )
 
 var (
@@ -12,7 +16,11 @@ var (
 	NULL  = &object.Null{}
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// DefaultMaxCallDepth is how deep calls can nest when the environment a
+// function was defined in doesn't set Options.MaxCallDepth
+const DefaultMaxCallDepth = 10000
+
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -20,46 +28,61 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalHashLiteral(node, env)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return locate(evalIndexExpression(left, index), node.Token, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	case *ast.BlockStatement:
 		return evalBlockStatements(node, env)
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := evalNamed(node.Value, node.Name.Value, env)
+		if isAbrupt(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		return locate(declare(env, node.Name.Value, val, node.Const), node.Token, env)
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return newFunction(node, node.Name, env)
+	case *ast.MacroLiteral:
+		return locate(newError(object.ERROR, "macros can only be bound by top-level let statements"), node.Token, env)
+	case *ast.FunctionStatement:
+		// Already bound by hoistFunctions when the enclosing block started
+		return nil
 	case *ast.CallExpression:
+		if isCallTo(node, "quote") && len(node.Arguments) == 1 {
+			return quote(node.Arguments[0], env)
+		}
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		fn := applyFunction(function, args)
-		return fn
+		if caller := env.Frame(); node.Tail && caller != nil && len(caller.Deferred) == 0 {
+			// The callee takes over the caller's place on the stack
+			frame := object.NewTailFrame(caller, node.Token.File, node.Token.Line, node.Token.Column)
+			frame.Function, frame.Env = calleeName(node.Function), env
+			return &tailCall{fn: function, args: args, frame: frame}
+		}
+		frame := object.NewFrame(env.Frame(), node.Token.File, node.Token.Line, node.Token.Column)
+		frame.Function, frame.Env = calleeName(node.Function), env
+		return locate(applyFunction(function, args, frame), node.Token, env)
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return locate(evalIdentifier(node, env), node.Token, env)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
@@ -67,50 +90,147 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalIfExpression(node, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return locate(evalPrefixExpression(node.Operator, right), node.Token, env)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		if !builtinOperators[node.Operator] {
+			return evalUserOperator(node, left, right, env)
+		}
+		return locate(evalInfixExpression(node.Operator, left, right), node.Token, env)
+	case *ast.InfixStatement:
+		fn := evalNamed(node.Function, node.Operator, env)
+		if isAbrupt(fn) {
+			return fn
+		}
+		// Operators aren't valid identifiers, so the binding can't clash
+		// w/ a variable
+		env.Set(node.Operator, fn)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 	case *ast.Boolean:
 		return getGlobalBool(node.Value)
+	case *ast.NullLiteral:
+		return NULL
+	case *ast.SymbolLiteral:
+		return object.Intern(node.Value)
+	case *ast.MatchExpression:
+		return locate(evalMatchExpression(node, env), node.Token, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.DeferStatement:
+		return evalDeferStatement(node, env)
+	case *ast.YieldExpression:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return locate(evalYield(val, env), node.Token, env)
+	case *ast.ForExpression:
+		return locate(evalForExpression(node, env), node.Token, env)
+	case *ast.SpawnExpression:
+		return evalSpawnExpression(node, env)
+	case *ast.AwaitExpression:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return locate(evalAwait(val), node.Token, env)
+	case *ast.ImportStatement:
+		return locate(evalImportStatement(node, env), node.Token, env)
+	case *ast.ExportStatement:
+		return Eval(node.Statement, env)
+	case *ast.SelectExpression:
+		return locate(evalSelectExpression(node, env), node.Token, env)
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return locate(thrownError(val), node.Token, env)
+	case *ast.StructStatement:
+		fields := []string{}
+		for _, f := range node.Fields {
+			fields = append(fields, f.Value)
+		}
+		return locate(declare(env, node.Name.Value, &object.StructType{Name: node.Name.Value, Fields: fields}, false), node.Token, env)
+	case *ast.ImplStatement:
+		return evalImplStatement(node, env)
+	case *ast.EnumStatement:
+		et := &object.EnumType{Name: node.Name.Value}
+		for _, v := range node.Variants {
+			fields := []string{}
+			for _, f := range v.Fields {
+				fields = append(fields, f.Value)
+			}
+			et.AddVariant(v.Name.Value, fields...)
+		}
+		return locate(declare(env, node.Name.Value, et, false), node.Token, env)
+	case *ast.MemberExpression:
+		obj := Eval(node.Object, env)
+		if isAbrupt(obj) {
+			return obj
+		}
+		return locate(evalMemberExpression(obj, node.Property.Value), node.Token, env)
+	case *ast.AssignExpression:
+		return locate(evalAssignExpression(node, env), node.Token, env)
+	case *ast.PropagateExpression:
+		val := Eval(node.Left, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return locate(evalPropagateExpression(val), node.Token, env)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
+	default:
+		return evalCustomNode(node, env)
 	}
 	return nil
 }
 
+func newFunction(node *ast.FunctionLiteral, name string, env *object.Environment) *object.Function {
+	return &object.Function{Name: name, Generator: node.Generator, Parameters: node.Parameters, Body: node.Body, Env: env}
+}
+
+// evalNamed evaluates the value bound to name. An anonymous function literal
+// is named after the binding for stack traces, as it's created: a function
+// that already exists may be in use by other tasks, so it's never renamed
+func evalNamed(expr ast.Expression, name string, env *object.Environment) object.Object {
+	if fl, ok := expr.(*ast.FunctionLiteral); ok && fl.Name == "" {
+		return newFunction(fl, name, env)
+	}
+	return Eval(expr, env)
+}
+
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
 	for k, v := range node.Pairs {
 		key := Eval(k, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 
 		hk, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", object.TypeName(key))
 		}
 
 		val := Eval(v, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		hashed := hk.HashKey()
@@ -128,7 +248,7 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(object.OPERATOR_ERROR, "index operator not supported: %s", object.TypeName(left))
 	}
 }
 
@@ -136,7 +256,7 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(object.TYPE_ERROR, "unusable as hash key: %s", object.TypeName(index))
 	}
 	pair, ok := hashObject.Pairs[key.HashKey()]
 	if !ok {
@@ -146,47 +266,183 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 }
 
+// Negative indexes count from the end; any index outside the array, in
+// either direction, is an IndexError
 func evalArrayIndexExpression(left, index object.Object) object.Object {
 	arr := left.(*object.Array)
-	ix := index.(*object.Integer).Value
-	max := int64(len(arr.Elements) - 1)
-	if ix < 0 {
-		return arr.Elements[len(arr.Elements)+int(ix)]
-	}
-	if ix > max {
-		return NULL
+	ix, ok := elementIndex(index.(*object.Integer).Value, len(arr.Elements))
+	if !ok {
+		return newError(object.INDEX_ERROR, "index out of range: %d (length %d)", index.(*object.Integer).Value, len(arr.Elements))
 	}
 	return arr.Elements[ix]
 }
 
 func evalStringIndexExpression(left, index object.Object) object.Object {
 	str := left.(*object.String).Value
-	ix := index.(*object.Integer).Value
-	max := int64(len(str) - 1)
+	ix, ok := elementIndex(index.(*object.Integer).Value, len(str))
+	if !ok {
+		return newError(object.INDEX_ERROR, "index out of range: %d (length %d)", index.(*object.Integer).Value, len(str))
+	}
+	return &object.String{Value: string(str[ix])}
+}
+
+// elementIndex resolves ix, which may count from the end, against a sequence
+// of length n. It reports false if that's outside the sequence
+func elementIndex(ix int64, n int) (int64, bool) {
 	if ix < 0 {
-		return &object.String{Value: string(str[len(str)+int(ix)])}
+		ix += int64(n)
 	}
-	if ix > max {
-		return NULL
+	return ix, ix >= 0 && ix < int64(n)
+}
+
+// tailCall is what a call in tail position evaluates to: rather than growing
+// the Go stack, it's handed back to applyFunction, which runs it in place of
+// the function that made it
+type tailCall struct {
+	fn    object.Object
+	args  []object.Object
+	frame *object.Frame
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// applyFunction calls fn w/ args; frame is the new frame for this call, whose
+// parent is the caller's frame
+func applyFunction(fn object.Object, args []object.Object, frame *object.Frame) object.Object {
+	return finishTailCalls(callFunction(fn, args, frame))
+}
+
+// finishTailCalls runs the chain of tail calls result might start
+func finishTailCalls(result object.Object) object.Object {
+	for {
+		tc, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
+		// Errors raised by the call itself (arity, not a function) point at
+		// the tail call site in the caller, as locate would have done for a
+		// regular call
+		result = callFunction(tc.fn, tc.args, tc.frame)
+		if err, ok := result.(*object.Error); ok && err.Line == 0 {
+			err.File, err.Line, err.Column = tc.frame.CallSite()
+			err.Frame = tc.frame.Caller()
+		}
 	}
-	return &object.String{Value: string(str[ix])}
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func callFunction(fn object.Object, args []object.Object, frame *object.Frame) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		// Recursion always goes through functions, so only their calls need
+		// checking. The limit is the one of the code that defined fn
+		if limit := maxCallDepth(fn.Env); frame.Depth > limit {
+			return newError(object.STACK_OVERFLOW, "maximum call depth exceeded (%d)", limit)
+		}
+		if fn.Name != "" {
+			frame.Function = fn.Name
+		}
+		if len(args) != len(fn.Parameters) {
+			return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d", len(args), len(fn.Parameters))
+		}
+		if fn.Generator {
+			return newGenerator(fn, args, frame)
+		}
+		return evalFunctionBody(fn, args, frame)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		return callBuiltin(fn, args, frame)
+	case *object.StructType:
+		return newInstance(fn, args)
+	case *object.BoundMethod:
+		return applyFunction(fn.Method, append([]object.Object{fn.Receiver}, args...), frame)
+	case *object.Variant:
+		if len(args) != len(fn.Fields) {
+			return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d", len(args), len(fn.Fields))
+		}
+		if fn.Unit != nil {
+			return fn.Unit
+		}
+		return &object.EnumValue{Variant: fn, Values: args}
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(object.TYPE_ERROR, "not a function: %s", object.TypeName(fn))
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+func maxCallDepth(env *object.Environment) int {
+	if limit := env.Options().MaxCallDepth; limit > 0 {
+		return limit
+	}
+	return DefaultMaxCallDepth
+}
+
+func evalFunctionBody(fn *object.Function, args []object.Object, frame *object.Frame) object.Object {
+	extendedEnv := extendFunctionEnv(fn, args, frame)
+	evaluated := Eval(fn.Body, extendedEnv)
+	return runDeferred(frame, unwrapReturnValue(evaluated))
+}
+
+func evalDeferStatement(node *ast.DeferStatement, env *object.Environment) object.Object {
+	frame := env.Frame()
+	if frame == nil {
+		return newError(object.ERROR, "defer outside function")
+	}
+
+	function := Eval(node.Call.Function, env)
+	if isAbrupt(function) {
+		return function
+	}
+	args := evalExpressions(node.Call.Arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return args[0]
+	}
+
+	frame.Deferred = append(frame.Deferred, &object.Deferred{
+		Fn:     function,
+		Args:   args,
+		File:   node.Token.File,
+		Line:   node.Token.Line,
+		Column: node.Token.Column,
+	})
+	return nil
+}
+
+// runDeferred runs the calls queued in frame by `defer`, last in first out,
+// once its function has finished w/ result. An error from a deferred call
+// replaces a successful result, but not an error that's already unwinding
+func runDeferred(frame *object.Frame, result object.Object) object.Object {
+	for len(frame.Deferred) > 0 {
+		last := len(frame.Deferred) - 1
+		d := frame.Deferred[last]
+		frame.Deferred = frame.Deferred[:last]
+
+		res := applyFunction(d.Fn, d.Args, object.NewFrame(frame, d.File, d.Line, d.Column))
+		if isError(res) && !isError(result) {
+			result = res
+		}
+	}
+	return result
+}
+
+// callBuiltin runs a builtin, turning a panic in its Go code into an error
+// raised at the call site
+func callBuiltin(fn *object.Builtin, args []object.Object, frame *object.Frame) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			name := frame.Function
+			if name == "" {
+				name = "builtin"
+			}
+			result = newError(object.INTERNAL_ERROR, "internal error in %s: %v", name, r)
+		}
+	}()
+	if fn.FrameFn != nil {
+		return fn.FrameFn(frame, args...)
+	}
+	return fn.Fn(args...)
+}
+
+func extendFunctionEnv(fn *object.Function, args []object.Object, frame *object.Frame) *object.Environment {
+	env := object.NewCallEnvironment(fn.Env, frame)
 	for ix, param := range fn.Parameters {
 		env.Set(param.Value, args[ix])
 	}
@@ -204,7 +460,7 @@ func evalExpressions(exprs []ast.Expression, env *object.Environment) []object.O
 	var results []object.Object
 	for _, expr := range exprs {
 		evaluated := Eval(expr, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		results = append(results, evaluated)
@@ -216,15 +472,27 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
+	if val, ok := lookupPrelude(node.Value, env); ok {
+		return val
+	}
+
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	if val, ok := builtinValues[node.Value]; ok {
+		return val
+	}
+
+	return newError(object.NAME_ERROR, "identifier not found: " + node.Value)
 }
-func evalBlockStatements(block *ast.BlockStatement, env *object.Environment) object.Object {
-	var result object.Object
-	for _, stmt := range block.Statements {
+func evalBlockStatements(block *ast.BlockStatement, env *object.Environment) (result object.Object) {
+	var stmt ast.Statement
+	defer recoverPanic(&result, &stmt, env)
+	if err := hoistFunctions(block.Statements, env); err != nil {
+		return err
+	}
+	for _, stmt = range block.Statements {
 		result = Eval(stmt, env)
 		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
 			return result
@@ -233,9 +501,13 @@ func evalBlockStatements(block *ast.BlockStatement, env *object.Environment) obj
 	return result
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
-	for _, stmt := range program.Statements {
+func evalProgram(program *ast.Program, env *object.Environment) (result object.Object) {
+	var stmt ast.Statement
+	defer recoverPanic(&result, &stmt, env)
+	if err := hoistFunctions(program.Statements, env); err != nil {
+		return err
+	}
+	for _, stmt = range program.Statements {
 		result = Eval(stmt, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -247,19 +519,425 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
+// recoverPanic is deferred by every block. No script should be able to crash
+// the host, so a panic that got past every other check becomes an
+// InternalError raised at the statement being run, *stmt. From there it
+// unwinds like any other error, through catch blocks and deferred calls, w/
+// the script's call stack as its trace. The Go stack is its payload
+func recoverPanic(result *object.Object, stmt *ast.Statement, env *object.Environment) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(stopGenerator); ok {
+		// Not a failure: an abandoned generator unwinding its goroutine
+		panic(r)
+	}
+	err := newError(object.INTERNAL_ERROR, "internal error: %v", r)
+	err.Payload = &object.String{Value: string(debug.Stack())}
+	*result = locate(err, nodeToken(*stmt), env)
+}
+
+// nodeToken is the token kept in node's Token field, which every statement
+// has, custom ones included
+func nodeToken(node ast.Node) token.Token {
+	if stmt, ok := node.(*ast.ExpressionStatement); ok && stmt.Token.Line == 0 && stmt.Expression != nil {
+		// Built w/o a token, e.g. by the s-expression reader
+		return nodeToken(stmt.Expression)
+	}
+	v := reflect.ValueOf(node)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		if field := v.Elem().FieldByName("Token"); field.IsValid() {
+			if tok, ok := field.Interface().(token.Token); ok {
+				return tok
+			}
+		}
+	}
+	return token.Token{}
+}
+
+// hoistFunctions binds every function declared in stmts before any of them
+// runs, so declarations can call each other regardless of their order
+func hoistFunctions(stmts []ast.Statement, env *object.Environment) object.Object {
+	for _, stmt := range stmts {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			stmt = export.Statement
+		}
+		if decl, ok := stmt.(*ast.FunctionStatement); ok {
+			if err := declare(env, decl.Name.Value, Eval(decl.Function, env), false); err != nil {
+				return locate(err, decl.Token, env)
+			}
+		}
+	}
+	return nil
+}
+
+// declare binds name for a declaration, which can't rebind a constant
+func declare(env *object.Environment, name string, val object.Object, constant bool) object.Object {
+	if _, ok := env.Declare(name, val, constant); !ok {
+		return newError(object.ASSIGNMENT_ERROR, "cannot redeclare constant %s", name)
+	}
+	return nil
+}
+
 func evalIfExpression(statement *ast.IfExpression, env *object.Environment) object.Object {
 	cond := Eval(statement.Condition, env)
-	if isError(cond) {
+	if isAbrupt(cond) {
 		return cond
 	}
 	if isTruthy(cond) {
-		return evalBlockStatements(statement.Consequence, env)
+		return evalBlockStatements(statement.Consequence, blockEnv(env))
 	} else if statement.Alternative != nil {
-		return evalBlockStatements(statement.Alternative, env)
+		return evalBlockStatements(statement.Alternative, blockEnv(env))
 	}
 	return NULL
 }
 
+// blockEnv is the environment a nested block runs in
+func blockEnv(env *object.Environment) *object.Environment {
+	if env.Options().SharedBlockScope {
+		return env
+	}
+	return object.NewEnclosedEnvironment(env)
+}
+
+func evalImplStatement(node *ast.ImplStatement, env *object.Environment) object.Object {
+	target, ok := env.Get(node.Name.Value)
+	if !ok {
+		return newError(object.NAME_ERROR, "identifier not found: " + node.Name.Value)
+	}
+	var methods *object.MethodTable
+	switch target := target.(type) {
+	case *object.StructType:
+		methods = &target.Methods
+	case *object.EnumType:
+		methods = &target.Methods
+	default:
+		return newError(object.TYPE_ERROR, "cannot impl %s", object.TypeName(target))
+	}
+
+	for _, m := range node.Methods {
+		methods.Set(m.Name.Value, &object.Function{
+			Name:       m.Name.Value,
+			Generator:  m.Function.Generator,
+			Parameters: m.Function.Parameters,
+			Body:       m.Function.Body,
+			Env:        env,
+		})
+	}
+	return nil
+}
+
+func newInstance(st *object.StructType, args []object.Object) object.Object {
+	if len(args) != len(st.Fields) {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d", len(args), len(st.Fields))
+	}
+	fields := make(map[string]object.Object, len(args))
+	for ix, name := range st.Fields {
+		fields[name] = args[ix]
+	}
+	return object.NewInstance(st, fields)
+}
+
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	switch obj := obj.(type) {
+	case *object.Instance:
+		if val, ok := obj.Get(name); ok {
+			return val
+		}
+		if method, ok := obj.Struct.Methods.Get(name); ok {
+			return &object.BoundMethod{Receiver: obj, Name: name, Method: method}
+		}
+		return newError(object.MEMBER_ERROR, "unknown field %s on %s", name, obj.Struct.Name)
+	case *object.EnumType:
+		variant, ok := obj.Variant(name)
+		if !ok {
+			return newError(object.MEMBER_ERROR, "unknown variant %s on %s", name, obj.Name)
+		}
+		if variant.Unit != nil {
+			return variant.Unit
+		}
+		return variant
+	case *object.Exception:
+		return evalExceptionMember(obj, name)
+	case *object.Module:
+		if val, ok := obj.Export(name); ok {
+			return val
+		}
+		return newError(object.MEMBER_ERROR, "module %s has no export %s", obj.Name, name)
+	case *object.EnumValue:
+		for ix, field := range obj.Variant.Fields {
+			if field == name {
+				return obj.Values[ix]
+			}
+		}
+		if method, ok := obj.Variant.Enum.Methods.Get(name); ok {
+			return &object.BoundMethod{Receiver: obj, Name: name, Method: method}
+		}
+		return lookupMethod(obj, name)
+	default:
+		return lookupMethod(obj, name)
+	}
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if isAbrupt(val) {
+		return val
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		found, constant := env.Assign(target.Value, val)
+		if constant {
+			return newError(object.ASSIGNMENT_ERROR, "cannot assign to constant %s", target.Value)
+		}
+		if !found {
+			return newError(object.NAME_ERROR, "identifier not found: " + target.Value)
+		}
+	case *ast.MemberExpression:
+		obj := Eval(target.Object, env)
+		if isAbrupt(obj) {
+			return obj
+		}
+		instance, ok := obj.(*object.Instance)
+		if !ok {
+			return newError(object.ASSIGNMENT_ERROR, "cannot assign field %s on %s", target.Property.Value, object.TypeName(obj))
+		}
+		if !instance.Struct.HasField(target.Property.Value) {
+			return newError(object.MEMBER_ERROR, "unknown field %s on %s", target.Property.Value, instance.Struct.Name)
+		}
+		instance.Set(target.Property.Value, val)
+	default:
+		return newError(object.ASSIGNMENT_ERROR, "cannot assign to %s", node.Target.String())
+	}
+	return val
+}
+
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := evalBlockStatements(node.Block, blockEnv(env))
+
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if node.CatchParam != nil {
+			catchEnv.Set(node.CatchParam.Value, &object.Exception{Err: err})
+		}
+		result = evalBlockStatements(node.Catch, catchEnv)
+	}
+
+	if node.Finally != nil {
+		// An error or return from the finally block replaces whatever the
+		// try/catch blocks produced
+		if final := evalBlockStatements(node.Finally, blockEnv(env)); isAbrupt(final) {
+			return final
+		}
+	}
+	return result
+}
+
+// thrownError turns the operand of `throw` into an error in flight. Caught
+// exceptions are rethrown as is; anything else becomes a plain Error w/ the
+// value as its payload
+func thrownError(val object.Object) *object.Error {
+	switch val := val.(type) {
+	case *object.Exception:
+		return val.Err
+	case *object.String:
+		return &object.Error{Kind: object.ERROR, Message: val.Value}
+	default:
+		return &object.Error{Kind: object.ERROR, Message: val.Inspect(), Payload: val}
+	}
+}
+
+func evalExceptionMember(ex *object.Exception, name string) object.Object {
+	switch name {
+	case "kind":
+		return &object.String{Value: ex.Err.Kind}
+	case "message":
+		return &object.String{Value: ex.Err.Message}
+	case "payload":
+		if ex.Err.Payload == nil {
+			return NULL
+		}
+		return ex.Err.Payload
+	case "line":
+		return &object.Integer{Value: int64(ex.Err.Line)}
+	case "column":
+		return &object.Integer{Value: int64(ex.Err.Column)}
+	default:
+		return newError(object.MEMBER_ERROR, "unknown field %s on EXCEPTION", name)
+	}
+}
+
+func evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(node.Subject, env)
+	if isAbrupt(subject) {
+		return subject
+	}
+	if ev, ok := subject.(*object.EnumValue); ok {
+		if err := checkExhaustive(node, ev.Variant.Enum, env); err != nil {
+			return err
+		}
+	}
+
+	for _, arm := range node.Arms {
+		bindings := make(map[string]object.Object)
+		matched, err := matchPattern(arm.Pattern, subject, bindings, env)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		armEnv := object.NewEnclosedEnvironment(env)
+		for name, val := range bindings {
+			armEnv.Set(name, val)
+		}
+		return evalBlockStatements(arm.Body, armEnv)
+	}
+	return newError(object.MATCH_ERROR, "no match arm for value: %s", subject.Inspect())
+}
+
+// checkExhaustive makes sure a match over an enum value either has a catch-all
+// arm or an arm covering every variant of the enum
+func checkExhaustive(node *ast.MatchExpression, et *object.EnumType, env *object.Environment) object.Object {
+	covered := make(map[*object.Variant]bool)
+	for _, arm := range node.Arms {
+		if isIrrefutable(arm.Pattern) {
+			return nil
+		}
+		if v := coveredVariant(arm.Pattern, env); v != nil {
+			covered[v] = true
+		}
+	}
+
+	missing := []string{}
+	for _, v := range et.Variants {
+		if !covered[v] {
+			missing = append(missing, v.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return newError(object.MATCH_ERROR, "non-exhaustive match on %s: missing %s", et.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// coveredVariant returns the variant a pattern matches in full, i.e. a unit
+// variant or a constructor pattern whose fields are all irrefutable
+func coveredVariant(pattern ast.Expression, env *object.Environment) *object.Variant {
+	switch pattern := pattern.(type) {
+	case *ast.CallExpression:
+		for _, arg := range pattern.Arguments {
+			if !isIrrefutable(arg) {
+				return nil
+			}
+		}
+		if v, ok := Eval(pattern.Function, env).(*object.Variant); ok {
+			return v
+		}
+	case *ast.Identifier, *ast.MemberExpression:
+		if ev, ok := Eval(pattern, env).(*object.EnumValue); ok && ev.Variant.Unit == ev {
+			return ev.Variant
+		}
+	}
+	return nil
+}
+
+func isIrrefutable(pattern ast.Expression) bool {
+	ident, ok := pattern.(*ast.Identifier)
+	return ok && isBindingName(ident.Value)
+}
+
+// matchPattern tests value against pattern, collecting any variables the
+// pattern binds. `_` matches anything, lowercase identifiers bind whatever is
+// in their position, and every other pattern is evaluated and compared
+func matchPattern(
+	pattern ast.Expression, value object.Object,
+	bindings map[string]object.Object, env *object.Environment,
+) (bool, object.Object) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value == "_" {
+			return true, nil
+		}
+		if isBindingName(pattern.Value) {
+			bindings[pattern.Value] = value
+			return true, nil
+		}
+	case *ast.CallExpression:
+		// Constructor patterns like Shape.Circle(r) destructure enum values
+		callee := Eval(pattern.Function, env)
+		if isError(callee) {
+			return false, callee
+		}
+		variant, ok := callee.(*object.Variant)
+		if !ok {
+			return false, newError(object.PATTERN_ERROR, "invalid pattern: %s", pattern.String())
+		}
+		if len(pattern.Arguments) != len(variant.Fields) {
+			return false, newError(object.PATTERN_ERROR, "pattern %s.%s expects %d fields, got %d",
+				variant.Enum.Name, variant.Name, len(variant.Fields), len(pattern.Arguments))
+		}
+		ev, ok := value.(*object.EnumValue)
+		if !ok || ev.Variant != variant {
+			return false, nil
+		}
+		for ix, arg := range pattern.Arguments {
+			if matched, err := matchPattern(arg, ev.Values[ix], bindings, env); !matched || err != nil {
+				return matched, err
+			}
+		}
+		return true, nil
+	case *ast.ArrayLiteral:
+		arr, ok := value.(*object.Array)
+		if !ok || len(arr.Elements) != len(pattern.Elements) {
+			return false, nil
+		}
+		for ix, el := range pattern.Elements {
+			if matched, err := matchPattern(el, arr.Elements[ix], bindings, env); !matched || err != nil {
+				return matched, err
+			}
+		}
+		return true, nil
+	}
+
+	expected := Eval(pattern, env)
+	if isError(expected) {
+		return false, expected
+	}
+	return objectsEqual(expected, value), nil
+}
+
+func isBindingName(name string) bool {
+	return name[0] == '_' || 'a' <= name[0] && name[0] <= 'z'
+}
+
+func objectsEqual(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Integer:
+		b, ok := b.(*object.Integer)
+		return ok && a.Value == b.Value
+	case *object.String:
+		b, ok := b.(*object.String)
+		return ok && a.Value == b.Value
+	case *object.EnumValue:
+		// Same variant, equal payloads
+		b, ok := b.(*object.EnumValue)
+		if !ok || a.Variant != b.Variant {
+			return false
+		}
+		for ix := range a.Values {
+			if !objectsEqual(a.Values[ix], b.Values[ix]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
 func isTruthy(cond object.Object) bool {
 	switch cond {
 	case TRUE:
@@ -277,20 +955,26 @@ func evalInfixExpression(
 	operator string, left object.Object, right object.Object,
 ) object.Object {
 	switch {
-	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case operator == "==":
+		// Booleans, null and symbols are singletons, so identity is equality
+		// for them; enum values compare by variant and payload
+		return getGlobalBool(objectsEqual(left, right))
+	case operator == "!=":
+		return getGlobalBool(!objectsEqual(left, right))
+	case left.Type() != right.Type() || object.TypeName(left) != object.TypeName(right):
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s", object.TypeName(left), operator, object.TypeName(right))
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.OPERATOR_ERROR, "unknown operator: %s %s %s", object.TypeName(left), operator, object.TypeName(right))
 	}
 }
 
 func evalStringInfixExpression(operator string, l object.Object, r object.Object) object.Object {
 	if operator != "+" {
-		return newError("unknown operator: %s %s %s", l.Type(), operator, r.Type())
+		return newError(object.OPERATOR_ERROR, "unknown operator: %s %s %s", object.TypeName(l), operator, object.TypeName(r))
 	}
 	left := l.(*object.String).Value
 	right := r.(*object.String).Value
@@ -298,6 +982,20 @@ func evalStringInfixExpression(operator string, l object.Object, r object.Object
 	return &object.String{Value: left + right}
 }
 
+// evalInterpolatedString joins the text and embedded values of a string as
+// `str` would convert them, so strings are embedded w/o quotes
+func evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out strings.Builder
+	for _, part := range node.Parts {
+		val := Eval(part, env)
+		if isAbrupt(val) {
+			return val
+		}
+		out.WriteString(val.Inspect())
+	}
+	return &object.String{Value: out.String()}
+}
+
 func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	l := left.(*object.Integer).Value
 	r := right.(*object.Integer).Value
@@ -310,6 +1008,9 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "*":
 		return &object.Integer{Value: l * r}
 	case "/":
+		if r == 0 {
+			return newError(object.ZERO_DIVISION, "division by zero")
+		}
 		return &object.Integer{Value: l / r}
 	case ">":
 		return getGlobalBool(l > r)
@@ -320,7 +1021,7 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "!=":
 		return getGlobalBool(l != r)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.OPERATOR_ERROR, "unknown operator: %s %s %s", object.TypeName(left), operator, object.TypeName(right))
 	}
 }
 
@@ -331,7 +1032,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperator(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.OPERATOR_ERROR, "unknown operator: %s%s", operator, object.TypeName(right))
 	}
 }
 
@@ -350,7 +1051,7 @@ func evalBangPrefixOperator(operand object.Object) object.Object {
 
 func evalMinusPrefixOperator(operand object.Object) object.Object {
 	if operand.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", operand.Type())
+		return newError(object.OPERATOR_ERROR, "unknown operator: -%s", object.TypeName(operand))
 	}
 	value := operand.(*object.Integer).Value
 	return &object.Integer{Value: value * -1}
@@ -363,10 +1064,63 @@ func getGlobalBool(boolNode bool) *object.Boolean {
 	return FALSE
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+// locate attaches the position of tok and the current call stack to obj if
+// obj is an error that doesn't have a position yet, i.e. the innermost
+// expression that raised it
+func locate(obj object.Object, tok token.Token, env *object.Environment) object.Object {
+	if err, ok := obj.(*object.Error); ok && err.Line == 0 {
+		err.File, err.Line, err.Column = tok.File, tok.Line, tok.Column
+		err.Frame = env.Frame()
+	}
+	return obj
+}
+
+// callback calls fn on behalf of a builtin running in frame, e.g. the
+// function passed to map
+func callback(frame *object.Frame, fn object.Object, args ...object.Object) object.Object {
+	file, line, column := frame.CallSite()
+	return applyFunction(fn, args, object.NewFrame(frame, file, line, column))
+}
+
+// calleeName is the name a call shows up under in stack traces when the
+// function itself doesn't have one
+func calleeName(node ast.Expression) string {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return node.Value
+	case *ast.MemberExpression:
+		return node.Property.Value
+	}
+	return ""
+}
+
+// builtinOperators are the infix operators evalInfixExpression implements;
+// any other operator was declared by an infix statement
+var builtinOperators = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "<": true, ">": true, "==": true, "!=": true,
+}
+
+func evalUserOperator(node *ast.InfixExpression, left, right object.Object, env *object.Environment) object.Object {
+	fn, ok := env.Get(node.Operator)
+	if !ok {
+		return locate(newError(object.NAME_ERROR, "operator not defined: %s", node.Operator), node.Token, env)
+	}
+	frame := object.NewFrame(env.Frame(), node.Token.File, node.Token.Line, node.Token.Column)
+	frame.Function, frame.Env = node.Operator, env
+	return locate(applyFunction(fn, []object.Object{left, right}, frame), node.Token, env)
+}
+
+func newError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
 }
 
 func isError(obj object.Object) bool {
 	return obj != nil && obj.Type() == object.ERROR_OBJ
 }
+
+// isAbrupt reports whether obj cuts evaluation of the surrounding expression
+// short: either an error or a return triggered from inside the expression
+// (e.g. by `?` or a `return` in an if block used as a value)
+func isAbrupt(obj object.Object) bool {
+	return obj != nil && (obj.Type() == object.ERROR_OBJ || obj.Type() == object.RETURN_VALUE_OBJ)
+}
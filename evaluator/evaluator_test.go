@@ -1,10 +1,19 @@
 package evaluator
 
 import (
+	"fmt"
+	"lang/ast"
 	"lang/lexer"
 	"lang/object"
 	"lang/parser"
+	"lang/token"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -232,6 +241,49 @@ func TestStringConcatenation(t *testing.T) {
 		t.Errorf("String has wrong value. got=%q", str.Value)
 	}
 }
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let n = 4; "count: ${n + 1}"`, "count: 5"},
+		{`let name = "world"; "hello ${name}!"`, "hello world!"},
+		{`"${[1, "a"]} ${:ok} ${null} ${Some(1)}"`, "[1, a] :ok null Option.Some(1)"},
+		{`let h = {"k": 1}; "${h["k"]}${ {"k": 2}["k"] }"`, "12"},
+		{`let x = 2; "outer ${"inner ${x * x}"}"`, "outer inner 4"},
+		{`"\${missing}"`, "${missing}"},
+		{`let n = 3; "\$${n} or \\n"`, "$3 or \\\\n"},
+		{`"count: " + str(5)`, "count: 5"},
+		{`str("a") + str(true)`, "atrue"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("%s: object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("%s: expected=%q, got=%q", tt.input, tt.expected, str.Value)
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`"a ${-true} b"`, "unknown operator: -BOOLEAN"},
+		{`"${missing}"`, "identifier not found: missing"},
+		{`str(1, 2)`, "wrong number of arguments. got=2, want=1"},
+	}
+	for _, tt := range errors {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok || errObj.Message != tt.expected {
+			t.Errorf("%s: expected error %q, got=%+v", tt.input, tt.expected, errObj)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -309,9 +361,6 @@ func TestArrayIndexExpressions(t *testing.T) {
 		}, {
 			"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]",
 			2},
-		{
-			"[1, 2, 3][3]",
-			nil},
 		{
 			"[1, 2, 3][-1]",
 			3,
@@ -329,6 +378,24 @@ func TestArrayIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+// testExpectedObject checks obj against the expected value of a test table
+// row: an int for an integer, a string for what obj inspects as, errors
+// included
+func testExpectedObject(t *testing.T, obj object.Object, expected interface{}) bool {
+	t.Helper()
+	switch expected := expected.(type) {
+	case int:
+		return testIntegerObject(t, obj, int64(expected))
+	case string:
+		if obj == nil || obj.Inspect() != expected {
+			t.Errorf("expected=%q, got=%+v", expected, obj)
+			return false
+		}
+	}
+	return true
+}
+
 func testNullObject(t *testing.T, obj object.Object) bool {
 	if obj != NULL {
 		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
@@ -402,3 +469,989 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestNullAndSymbols(t *testing.T) {
+	testNullObject(t, testEval("null"))
+	testBooleanObject(t, testEval(":ok == :ok"), true)
+	testBooleanObject(t, testEval(":ok == :error"), false)
+	testBooleanObject(t, testEval("null != :ok"), true)
+
+	evaluated := testEval(":ok")
+	sym, ok := evaluated.(*object.Symbol)
+	if !ok {
+		t.Fatalf("object is not Symbol. got=%T (%+v)", evaluated, evaluated)
+	}
+	if sym != object.Intern("ok") {
+		t.Errorf("symbol literal was not interned")
+	}
+	if sym.Inspect() != ":ok" {
+		t.Errorf("symbol has wrong Inspect. got=%q", sym.Inspect())
+	}
+
+	testIntegerObject(t, testEval(`{:ok: 1, :error: 2}[:error]`), 2)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (:ok) { :ok => 1, :error => 2 }`, 1},
+		{`match (:error) { :ok => 1, :error => 2 }`, 2},
+		{`match (5) { 1 => 1, n => n * 2 }`, 10},
+		{`match ("a") { "b" => 1, _ => 3 }`, 3},
+		{`match (null) { null => { let x = 4; x } }`, 4},
+		{`match ([1, 2]) { [a, b] => a + b }`, 3},
+		{`match (3) { 1 => 1 }`, "MatchError: no match arm for value: 3"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestStructs(t *testing.T) {
+	prelude := `struct Point { x, y }
+impl Point {
+	fn norm(self) { self.x * self.x + self.y * self.y }
+	fn shift(self, d) { self.x = self.x + d; self }
+}
+let p = Point(3, 4);
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"p.x", 3},
+		{"p.norm()", 25},
+		{"p.shift(2).x", 5},
+		{"p.y = 7; p.y", 7},
+		{"let a = 1; a = 2; a", 2},
+		{"p.z", "MemberError: unknown field z on Point"},
+		{"p.z = 1", "MemberError: unknown field z on Point"},
+		{"Point(1)", "ArgumentError: wrong number of arguments. got=1, want=2"},
+		{"p + 1", "TypeError: type mismatch: Point + INTEGER"},
+		{"struct INTEGER { v }; -INTEGER(1)", "OperatorError: unknown operator: -INTEGER"},
+		{"struct STRING { v }; STRING(1) + \"a\"", "TypeError: type mismatch: STRING + STRING"},
+		{"b = 1", "NameError: identifier not found: b"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(prelude + tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	if inspected := testEval(prelude + "p").Inspect(); inspected != "Point{x: 3, y: 4}" {
+		t.Errorf("instance has wrong Inspect. got=%q", inspected)
+	}
+}
+
+func TestEnums(t *testing.T) {
+	prelude := `enum Shape { Circle(r), Rect(w, h), Empty }
+let area = fn(s) {
+	match (s) {
+		Shape.Circle(r) => 3 * r * r,
+		Shape.Rect(w, h) => w * h,
+		Shape.Empty => 0
+	}
+};
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"area(Shape.Circle(2))", 12},
+		{"area(Shape.Rect(2, 5))", 10},
+		{"area(Shape.Empty)", 0},
+		{"Shape.Rect(2, 5).h", 5},
+		{"match (Shape.Rect(1, 2)) { Shape.Rect(1, h) => h, _ => 0 }", 2},
+		{"match (Shape.Rect(3, 2)) { Shape.Rect(1, h) => h, _ => 0 }", 0},
+		{"match (Some(Shape.Circle(3))) { Some(Shape.Circle(3)) => 1, _ => 0 }", 1},
+		{"match (Some(Shape.Circle(3))) { Some(Shape.Circle(4)) => 1, _ => 0 }", 0},
+		{"let C = Shape.Circle(3); match (Shape.Circle(3)) { C => 1, _ => 0 }", 1},
+		{"if (Shape.Circle(3) == Shape.Circle(3)) { 1 } else { 0 }", 1},
+		{"if (Shape.Circle(3) != Shape.Circle(4)) { 1 } else { 0 }", 1},
+		{"if (Shape.Rect(1, 2) == Shape.Rect(1, 3)) { 1 } else { 0 }", 0},
+		{"if (Some(1) == Ok(1)) { 1 } else { 0 }", 0},
+		{"if (Shape.Empty == Shape.Empty) { 1 } else { 0 }", 1},
+		{"match (Shape.Empty) { Shape.Circle(r) => r }", "MatchError: non-exhaustive match on Shape: missing Rect, Empty"},
+		{"match (Shape.Empty) { Shape.Circle(r, x) => r, _ => 0 }", "PatternError: pattern Shape.Circle expects 1 fields, got 2"},
+		{"Shape.Circle(1, 2)", "ArgumentError: wrong number of arguments. got=2, want=1"},
+		{"Shape.Square", "MemberError: unknown variant Square on Shape"},
+		{"enum INTEGER { A(v) }; -INTEGER.A(1)", "OperatorError: unknown operator: -INTEGER"},
+		{"enum Option { Some(v) }; Option.Some(1).unwrap()", "MemberError: no method unwrap on Option"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(prelude + tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	if inspected := testEval(prelude + "Shape.Circle(3)").Inspect(); inspected != "Shape.Circle(3)" {
+		t.Errorf("enum value has wrong Inspect. got=%q", inspected)
+	}
+}
+
+func TestOptionAndResult(t *testing.T) {
+	prelude := `let half = fn(n) { if (n > 0) { Ok(n / 2) } else { Err("not positive") } };
+let quarter = fn(n) { let h = half(n)?; half(h) };
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"unwrap(Some(5))", 5},
+		{"unwrap_or(None, 3)", 3},
+		{"unwrap_or(Some(1), 3)", 1},
+		{"unwrap(map(Some(2), fn(x) { x * 10 }))", 20},
+		{"unwrap(quarter(8))", 2},
+		{"match (quarter(0)) { Ok(v) => v, Err(e) => -1 }", -1},
+		{"let f = fn() { [1, None?, 2] }; unwrap_or(f(), 9)", 9},
+		{"unwrap(None)", "UnwrapError: called `unwrap` on Option.None"},
+		{"unwrap(1)", "TypeError: argument to `unwrap` not supported, got INTEGER"},
+		{"5?", "OperatorError: operator ? not supported: INTEGER"},
+		{"match (Some(1)) { Some(v) => v }", "MatchError: non-exhaustive match on Option: missing None"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(prelude + tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	testBooleanObject(t, testEval("is_some(Some(1))"), true)
+	testBooleanObject(t, testEval("is_none(Some(1))"), false)
+	testBooleanObject(t, testEval("is_err(Err(1))"), true)
+	if inspected := testEval(prelude + "quarter(0)").Inspect(); inspected != "Result.Err(not positive)" {
+		t.Errorf("propagated value has wrong Inspect. got=%q", inspected)
+	}
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"abc".len()`, 3},
+		{`[1, 2, 3, 4].map(fn(x) { x * 2 }).filter(fn(x) { x > 2 }).len()`, 3},
+		{`[1, 2, 3].reduce(0, fn(acc, x) { acc + x })`, 6},
+		{`{"a": 1, "b": 2}.values().reduce(0, fn(acc, x) { acc + x })`, 3},
+		{`Some(4).map(fn(x) { x + 1 }).unwrap()`, 5},
+		{`enum E { A, B } impl E { fn id(self) { match (self) { E.A => 1, E.B => 2 } } } E.B.id()`, 2},
+		{`"abc".foo()`, "MemberError: no method foo on STRING"},
+		{`5.len()`, "MemberError: no method len on INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	stringTests := map[string]string{
+		`"abc".upper()`:             `ABC`,
+		`" a b ".trim().split(" ")`: `[a, b]`,
+		`{"b": 1, "a": 2}.keys()`:   `[a, b]`,
+		`[1, 2].join(", ")`:         `1, 2`,
+		`{"a": 1}.has("a")`:         `true`,
+		`"hello".contains("ell")`:   `true`,
+	}
+	for input, expected := range stringTests {
+		if inspected := testEval(input).Inspect(); inspected != expected {
+			t.Errorf("%s: expected=%q, got=%q", input, expected, inspected)
+		}
+	}
+}
+
+func TestTryCatchFinally(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw "boom"; 1 } catch (e) { 2 }`, 2},
+		{`try { 1 + true } catch (e) { e.kind }`, "TypeError"},
+		{`try { -true } catch (e) { e.kind }`, "OperatorError"},
+		{`try { len(1, 2) } catch (e) { e.kind }`, "ArgumentError"},
+		{`try { nope } catch (e) { e.message }`, "identifier not found: nope"},
+		{`try { throw error("Custom", "bad", 42) } catch (e) { e.payload }`, 42},
+		{`try { throw error("Custom", "bad") } catch (e) { e.kind + ": " + e.message }`, "Custom: bad"},
+		{`try { throw 7 } catch (e) { e.payload }`, 7},
+		{`try { try { throw "in" } catch (e) { throw e } } catch (e) { e.message }`, "in"},
+		{`let x = 1; try { x = 2 } finally { x = 3 }; x`, 3},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, 1},
+		{`let f = fn() { try { throw "x" } catch { return 5 }; 6 }; f()`, 5},
+		{"try {\n  1 +\n    true\n} catch (e) { [e.line, e.column] }", "[2, 5]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	evaluated := testEval(`try { 1 } catch (e) { 2 } finally { throw "late" }`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Kind != object.ERROR || errObj.Message != "late" {
+		t.Errorf("wrong error. got=%s", errObj.Inspect())
+	}
+}
+
+func TestDefer(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let log = []; let f = fn() { defer push(log, 1); 2 }; f()`, 2},
+		{`let x = 0; let set = fn(v) { x = v }; let f = fn() { defer set(1); defer set(2); 0 }; f(); x`, 1},
+		{`let x = 0; let set = fn(v) { x = v }; let f = fn() { defer set(x + 5); x = 1; 0 }; f(); x`, 5},
+		{`let x = 0; let set = fn(v) { x = v }; let f = fn() { defer set(3); return 1; 2 }; f() + x`, 4},
+		{`let x = 0; let set = fn(v) { x = v }; let f = fn() { defer set(9); throw "boom" }; try { f() } catch (e) { x }`, 9},
+		{`let f = fn() { defer fn() { throw "late" }(); 1 }; try { f() } catch (e) { e.message }`, "late"},
+		{`let f = fn() { defer fn() { throw "late" }(); throw "first" }; try { f() } catch (e) { e.message }`, "first"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`fn add(a, b) { a + b } add(1, 2)`, 3},
+		{`let r = add(1, 2); fn add(a, b) { a + b } r`, 3},
+		{`fn even(n) { if (n == 0) { true } else { odd(n - 1) } }
+		  fn odd(n) { if (n == 0) { false } else { even(n - 1) } }
+		  odd(7)`, "true"},
+		{`let f = fn() { g() }; fn g() { 4 } f()`, 4},
+		{`fn add(a, b) { a + b } help(add)`, "fn add(a, b)"},
+		{`help(fn(x) { x })`, "fn(x)"},
+		{`help(len)`, "builtin function"},
+		{`fn twice(x) { x * 2 } twice`, "fn twice(x) {\n(x * 2)\n}"},
+		{`help(1)`, "TypeError: argument to `help` not supported, got INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestBlockScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let x = 1; if (true) { let x = 2; }; x`, 1},
+		{`let x = 1; if (true) { x = 2; }; x`, 2},
+		{`if (true) { let y = 2; }; y`, "NameError: identifier not found: y"},
+		{`let x = 1; try { let x = 5; x } finally { 0 }; x`, 1},
+		{`if (true) { fn f() { 3 } f() }`, 3},
+		{`const x = 1; x`, 1},
+		{`const x = 1; if (true) { let x = 2; x = 3; x }`, 3},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	// Separate programs, as on separate REPL lines, so only the runtime check
+	// can catch the reassignment
+	env := object.NewEnvironment()
+	Eval(parser.New(lexer.New(`const x = 1;`)).ParseProgram(), env)
+	evaluated := Eval(parser.New(lexer.New(`x = 2`)).ParseProgram(), env)
+	if evaluated == nil || evaluated.Inspect() != "AssignmentError: cannot assign to constant x" {
+		t.Errorf("expected const reassignment error, got=%+v", evaluated)
+	}
+	for _, input := range []string{`let x = 2`, `const x = 2`, `fn x() { 2 }`, `struct x { a }`} {
+		evaluated = Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+		if evaluated == nil || evaluated.Inspect() != "AssignmentError: cannot redeclare constant x" {
+			t.Errorf("%s: expected const redeclaration error, got=%+v", input, evaluated)
+		}
+	}
+	testIntegerObject(t, Eval(parser.New(lexer.New(`x`)).ParseProgram(), env), 1)
+
+	env = object.NewEnvironment()
+	env.SetOptions(object.Options{SharedBlockScope: true})
+	testIntegerObject(t, Eval(parser.New(lexer.New(`let x = 1; if (true) { let x = 2; }; x`)).ParseProgram(), env), 2)
+	testIntegerObject(t, Eval(parser.New(lexer.New(`fn f() { if (true) { let y = 3; }; y } f()`)).ParseProgram(), env), 3)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`fn count(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } } count(1000000, 0)`, 1000000},
+		{`fn count(n) { if (n == 0) { return 0 }; return count(n - 1) } count(100000)`, 0},
+		{`fn even(n) { if (n == 0) { true } else { odd(n - 1) } }
+		  fn odd(n) { if (n == 0) { false } else { even(n - 1) } }
+		  even(100000)`, "true"},
+		{`fn count(n) { match (n) { 0 => 0, _ => count(n - 1) } } count(100000)`, 0},
+		{`fn f(n) { if (n == 0) { throw "done" } else { f(n - 1) } } try { f(10) } catch (e) { e.message }`, "done"},
+		{`fn f() { g(1) } fn g() { 1 } f()`, "ArgumentError: wrong number of arguments. got=1, want=0"},
+		{`let x = 0; fn set() { x = x + 1 } fn f(n) { defer set(); if (n > 0) { f(n - 1) } } f(10); x`, 11},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`fn f(n) { 1 + f(n + 1) } f(0)`, "StackOverflow: maximum call depth exceeded (10000)"},
+		{`fn f(n) { 1 + f(n + 1) } try { f(0) } catch (e) { e.kind }`, "StackOverflow"},
+		{`fn f(n) { [1].map(fn(x) { f(n) }) } try { f(0) } catch (e) { e.kind }`, "StackOverflow"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: expected=%q, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	env := object.NewEnvironment()
+	env.SetOptions(object.Options{MaxCallDepth: 50})
+	testIntegerObject(t, Eval(parser.New(lexer.New(`fn f(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } } f(49)`)).ParseProgram(), env), 49)
+	evaluated := Eval(parser.New(lexer.New(`f(50)`)).ParseProgram(), env)
+	if evaluated == nil || evaluated.Inspect() != "StackOverflow: maximum call depth exceeded (50)" {
+		t.Errorf("expected the environment's limit, got=%+v", evaluated)
+	}
+	// eval and callbacks from builtins run under the same limit
+	for _, input := range []string{
+		`eval("fn g(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } } g(50)")`,
+		`["fn g(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } } g(50)"].map(eval)[0]`,
+	} {
+		evaluated = Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+		if evaluated == nil || evaluated.Inspect() != "StackOverflow: maximum call depth exceeded (50)" {
+			t.Errorf("%s: expected the caller's limit, got=%+v", input, evaluated)
+		}
+	}
+
+	evaluated = testEval(`fn f(n) { 1 + f(n + 1) } f(0)`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if trace := errObj.Trace(); len(trace) != 2*object.TraceEnds+1 {
+		t.Errorf("trace wasn't truncated. got %d lines", len(trace))
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`fn* three() { yield 1; yield 2; yield 3 } let g = three(); [next(g), next(g), next(g), next(g)]`,
+			"[Option.Some(1), Option.Some(2), Option.Some(3), Option.None]"},
+		{`fn* nat(n) { yield n } nat(0)`, "<generator nat>"},
+		{`range(3).collect()`, "[0, 1, 2]"},
+		{`range(2, 4).collect()`, "[2, 3]"},
+		{`let total = 0; for (x in [1, 2, 3]) { total = total + x }; total`, 6},
+		{`let s = ""; for (c in "abc") { s = c + s }; s`, "cba"},
+		{`let s = ""; for (k in {"b": 1, "a": 2}) { s = s + k }; s`, "ab"},
+		{`for (x in 5) { x }`, "TypeError: not iterable: INTEGER"},
+		{`fn f() { for (x in [1, 2, 3]) { if (x == 2) { return x } }; 0 } f()`, 2},
+		// Only the first few of a billion values are ever computed
+		{`range(1, 1000000000).map(fn(x) { x * x }).filter(fn(x) { x > 10 }).take(3).collect()`, "[16, 25, 36]"},
+		{`fn* squares(xs) { for (x in xs) { yield x * x } } map(squares(range(1, 1000000000)), fn(x) { x + 1 }).next()`,
+			"Option.Some(2)"},
+		{`fn* gen() { yield 1; throw "boom" } let g = gen(); [g.next(), try { g.next() } catch (e) { e.message }]`,
+			"[Option.Some(1), boom]"},
+		{`fn* gen() { yield 1; 1 / 0 } gen().collect()`, "ZeroDivisionError: division by zero"},
+		{`let n = 0; fn* gen() { defer fn() { n = 1 }(); yield 1 } gen().collect(); n`, 1},
+		{`let total = 0; fn* up(n) { for (x in [1, 2]) { yield x + n } } for (x in up(10)) { total = total + x }; total`, 23},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	// Tasks have to run in parallel for unsynchronized access to collide
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`fn add(a, b) { a + b } await spawn add(1, 2)`, 3},
+		{`let tasks = [spawn fn() { 1 }(), spawn fn() { 2 }()]; await tasks[0] + await tasks[1]`, 3},
+		{`fn f() { throw "in task" } let t = spawn f(); try { await t } catch (e) { e.message }`, "in task"},
+		{`await 1`, "TypeError: cannot await INTEGER"},
+		{`let ch = channel(); spawn fn() { ch.send(1); ch.send(2); ch.close() }(); [ch.recv(), recv(ch), ch.recv()]`,
+			"[Option.Some(1), Option.Some(2), Option.None]"},
+		{`let ch = channel(2); send(ch, 1); send(ch, 2); close(ch); [recv(ch), recv(ch), recv(ch)]`,
+			"[Option.Some(1), Option.Some(2), Option.None]"},
+		{`let ch = channel(); close(ch); ch.send(1)`, "Error: send on closed channel"},
+		{`let ch = channel(); close(ch); close(ch)`, "Error: close of closed channel"},
+		{`let ch = channel(); select { v = recv(ch) => v, _ => :empty }`, ":empty"},
+		{`let a = channel(1); let b = channel(1); send(b, 5); select { v = recv(a) => v, w = recv(b) => w }`, "Option.Some(5)"},
+		{`let a = channel(1); select { send(a, 7) => recv(a) }`, "Option.Some(7)"},
+		{`let a = channel(); close(a); select { send(a, 7) => 1 }`, "Error: send on closed channel"},
+		{`select { recv(1) => 1 }`, "TypeError: argument to `recv` not supported, got INTEGER"},
+		// Tasks share the environment they were spawned from
+		{`let n = 0; let bump = fn() { n = n + 1 }; let ts = [spawn bump(), spawn bump(), spawn bump()];
+		  await ts[0]; await ts[1]; await ts[2]; n > 0`, "true"},
+		{`let results = channel(10);
+		  let worker = fn(n) { results.send(n * n) };
+		  let tasks = [spawn worker(1), spawn worker(2), spawn worker(3)];
+		  await tasks[0]; await tasks[1]; await tasks[2];
+		  close(results);
+		  let total = 0;
+		  for (r in [recv(results), recv(results), recv(results)]) { total = total + r.unwrap() };
+		  total`, 14},
+		// Binding a function or adding methods while tasks use them
+		{`let fs = [fn() { 1 }]; let t = spawn fs[0](); let named = fs[0]; await t`, 1},
+		{`struct P { x } let p = P(2);
+		  let t = spawn fn() { let n = 0; for (i in range(200)) { n = n + try { p.get() } catch { 0 } }; n }();
+		  impl P { fn get(self) { self.x } }
+		  (await t) > -1`, "true"},
+		// Assigning and reading fields of one instance from several tasks
+		{`struct P { x } let p = P(0); let start = channel();
+		  let w = fn(n) { start.recv(); for (i in range(20000)) { p.x = n + i; p.x }; str(p) };
+		  let ts = [spawn w(1), spawn w(2), spawn w(3), spawn w(4)];
+		  close(start);
+		  await ts[0]; await ts[1]; await ts[2]; await ts[3];
+		  p.x > 0`, "true"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	libDir := t.TempDir()
+	files := map[string]string{
+		filepath.Join(dir, "math.mk"): `export fn square(x) { x * x }
+			export const two = 2;
+			let hidden = 3;`,
+		filepath.Join(dir, "a.mk"):      `import "b" as b; export let a = 1;`,
+		filepath.Join(dir, "b.mk"):      `import "a" as a; export let b = 2;`,
+		filepath.Join(dir, "broken.mk"): `let = ;`,
+		// Each waits until the other's loading before importing it back
+		filepath.Join(dir, "gate.mk"):    `export let c = channel(1); export let d = channel(1);`,
+		filepath.Join(dir, "c.mk"):       `import "gate" as g; g.c.send(1); g.d.recv(); import "d"`,
+		filepath.Join(dir, "d.mk"):       `import "gate" as g; g.d.send(1); g.c.recv(); import "c"`,
+		filepath.Join(libDir, "strs.mk"): `export fn shout(s) { s.upper() }`,
+		filepath.Join(dir, "hi.mki"): `export def hi(a: int, b: int) -> Option[int]:
+    if a > b:
+        return Some(a + b)
+    return None
+`,
+	}
+	for path, src := range files {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv(PathEnv, libDir)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "math.mk" as m; m.square(m.two)`, 4},
+		{`import "math"; math.square(3)`, 9},
+		{`import "math" as a; import "math.mk" as b; a == b`, "true"},
+		{`import "math" as m; m.hidden`, "MemberError: module math has no export hidden"},
+		{`import "strs" as s; s.shout("hi")`, "HI"},
+		{`import "hi"; [hi.hi(10, 10), hi.hi(3, 1)]`, "[Option.None, Option.Some(4)]"},
+		{`import "a"`, "ImportError: import cycle: a.mk -> b.mk -> a.mk"},
+		{`import "missing"`, "ImportError: module not found: missing"},
+	}
+	for _, tt := range tests {
+		main := filepath.Join(dir, "main.mk")
+		program := parser.New(lexer.NewFile(main, tt.input)).ParseProgram()
+		evaluated := Eval(program, ScriptEnvironment(main))
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	// Two scripts importing c and d at once: one of the imports closing the
+	// cycle has to fail, rather than both waiting for the other to finish
+	results := make(chan object.Object, 2)
+	for _, input := range []string{`import "c"`, `import "d"`} {
+		go func(input string) {
+			main := filepath.Join(dir, "main.mk")
+			results <- Eval(parser.New(lexer.NewFile(main, input)).ParseProgram(), ScriptEnvironment(main))
+		}(input)
+	}
+	for range [2]int{} {
+		select {
+		case evaluated := <-results:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || !strings.HasPrefix(errObj.Inspect(), "ImportError: import cycle: ") {
+				t.Errorf("expected an import cycle error, got=%+v", evaluated)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("concurrent imports of c and d deadlocked")
+		}
+	}
+
+	program := parser.New(lexer.NewFile(filepath.Join(dir, "main.mk"), `import "broken"`)).ParseProgram()
+	errObj, ok := Eval(program, object.NewEnvironment()).(*object.Error)
+	if !ok || errObj.Kind != object.IMPORT_ERROR {
+		t.Errorf("expected an ImportError for a module that doesn't parse, got=%+v", errObj)
+	}
+}
+
+func TestPrelude(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, "[3, 4]"},
+		{`reduce([1, 2, 3], 10, fn(acc, x) { acc + x })`, 16},
+		{`sum(range(5))`, 10},
+		{`collect("abc")`, "[a, b, c]"},
+		{`[any([1, 2], fn(x) { x == 2 }), all([1, 2], fn(x) { x == 2 })]`, "[true, false]"},
+		{`let seen = 0; each([1, 2, 3], fn(x) { seen = seen + x }); seen`, 6},
+		{`let sum = fn(xs) { 0 }; sum([1, 2])`, 0},
+		{`help(reduce)`, "fn reduce(xs, init, f)"},
+		{`filter(1, fn(x) { x })`, "TypeError: not iterable: INTEGER"},
+		{`len(filter(range(200000), fn(x) { x > 99999 }))`, 100000},
+		{`len(collect(range(200000)))`, 200000},
+		{`collect(iter({"b": 1, "a": 2}))`, "[a, b]"},
+		{`filter([1, 2], fn(x) { 1 / 0 })`, "ZeroDivisionError: division by zero"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	project := fstest.MapFS{
+		"b.mk": {Data: []byte(`fn twice(x) { x * 2 }`)},
+		"a.mk": {Data: []byte(`fn twice(x) { x + x + 0 }`)},
+	}
+	if err := LoadPrelude(project); err != nil {
+		t.Fatal(err)
+	}
+	if fn, ok := testEval(`twice`).(*object.Function); !ok || fn.Body.String() != "(x * 2)" {
+		t.Errorf("later prelude files should override earlier ones, got=%+v", fn)
+	}
+
+	// Top level prelude code looks builtins up while the prelude is loading
+	if err := LoadPrelude(fstest.MapFS{"c.mk": {Data: []byte(`let prelude_len = len(collect("abc"));`)}}); err != nil {
+		t.Fatal(err)
+	}
+	testIntegerObject(t, testEval(`prelude_len`), 3)
+
+	if err := LoadPrelude(fstest.MapFS{"bad.mk": {Data: []byte(`let = 1;`)}}); err == nil {
+		t.Errorf("expected an error for a prelude file that doesn't parse")
+	}
+}
+
+func TestErrorStackTraces(t *testing.T) {
+	input := `let check = fn(n) {
+	if (n == 0) { throw "hit zero" }
+	n
+};
+let count = fn(n) { check(n) + count(n - 1) };
+[1].map(fn(x) { count(x) })`
+
+	p := parser.New(lexer.NewFile("math.mk", input))
+	program := p.ParseProgram()
+	evaluated := Eval(program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := []string{
+		"at check (math.mk:2:16)",
+		"at count (math.mk:5:26)",
+		"at count (math.mk:5:37)",
+		"at <anonymous> (math.mk:6:22)",
+		"at map (math.mk:6:8)",
+		"at <main> (math.mk:6:8)",
+	}
+	trace := errObj.Trace()
+	if len(trace) != len(expected) {
+		t.Fatalf("trace has wrong length. got=%q", trace)
+	}
+	for i, line := range expected {
+		if trace[i] != line {
+			t.Errorf("trace[%d] wrong. expected=%q, got=%q", i, line, trace[i])
+		}
+	}
+
+	// Tail calls replace their caller's frame, but the trace still names it
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn inner(x) { throw \"no\" }\nfn outer(x) { inner(x) }\n[1].map(fn(x) { outer(x) })", []string{
+			"at inner (tail.mk:1:15)",
+			"at outer (tail.mk:2:20)",
+			"... 1 frame elided by tail calls",
+			"at map (tail.mk:3:8)",
+			"at <main> (tail.mk:3:8)",
+		}},
+		{"fn f(n) { eval(\"1 + true\") }\nf(1)", []string{
+			"at eval (<input>:1:3)",
+			"at f (tail.mk:1:15)",
+			"at <main> (tail.mk:2:2)",
+		}},
+		{"fn g() { 1 }\nfn f() { g(1) }\nf()", []string{
+			"at f (tail.mk:2:11)",
+			"at <main> (tail.mk:3:2)",
+		}},
+	}
+	for _, tt := range tests {
+		program := parser.New(lexer.NewFile("tail.mk", tt.input)).ParseProgram()
+		errObj, ok := Eval(program, object.NewEnvironment()).(*object.Error)
+		if !ok {
+			t.Fatalf("%s: expected an error, got=%+v", tt.input, errObj)
+		}
+		if trace := errObj.Trace(); fmt.Sprint(trace) != fmt.Sprint(tt.expected) {
+			t.Errorf("%s: wrong trace. expected=%q, got=%q", tt.input, tt.expected, trace)
+		}
+	}
+}
+
+func TestNoPanics(t *testing.T) {
+	RegisterMethod(object.INTEGER_OBJ, "boom", func(args ...object.Object) object.Object {
+		panic("boom")
+	})
+	defer delete(methods, object.INTEGER_OBJ)
+
+	tests := []struct {
+		input        string
+		expectedKind string
+		expectedMsg  string
+	}{
+		{"1 / 0", object.ZERO_DIVISION, "division by zero"},
+		{"let f = fn(x) { 10 / x }; f(0)", object.ZERO_DIVISION, "division by zero"},
+		{"[1][-5]", object.INDEX_ERROR, "index out of range: -5 (length 1)"},
+		{"[][-1]", object.INDEX_ERROR, "index out of range: -1 (length 0)"},
+		{`""[-1]`, object.INDEX_ERROR, "index out of range: -1 (length 0)"},
+		{"[1, 2, 3][3]", object.INDEX_ERROR, "index out of range: 3 (length 3)"},
+		{"[][0]", object.INDEX_ERROR, "index out of range: 0 (length 0)"},
+		{`"ab"[2]`, object.INDEX_ERROR, "index out of range: 2 (length 2)"},
+		{"first([])", object.INDEX_ERROR, "first of empty ARRAY"},
+		{"let f = fn(a, b) { a + b }; f(1)", object.ARGUMENT_ERROR, "wrong number of arguments. got=1, want=2"},
+		{"let f = fn(a) { a }; f(1, 2)", object.ARGUMENT_ERROR, "wrong number of arguments. got=2, want=1"},
+		{"5.boom()", object.INTERNAL_ERROR, "internal error in boom: boom"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind || errObj.Message != tt.expectedMsg {
+			t.Errorf("%s: wrong error. expected=%s: %s, got=%s",
+				tt.input, tt.expectedKind, tt.expectedMsg, errObj.Inspect())
+		}
+	}
+
+	testIntegerObject(t, testEval("first([7, 8])"), 7)
+	testIntegerObject(t, testEval("try { 1 / 0 } catch (e) { 3 }"), 3)
+
+	// A panic in the evaluator itself, here from a nil node no parser would
+	// produce, is raised where it happened and runs pending defers
+	nilBoom := func(node ast.Node) ast.Node {
+		if ident, ok := node.(*ast.Identifier); ok && ident.Value == "boom" {
+			return (*ast.Identifier)(nil)
+		}
+		return node
+	}
+	input := `let cleaned = false;
+fn clean() { cleaned = true }
+fn f() {
+	defer clean();
+	boom[0]
+}
+let kind = try { f() } catch (e) { e.kind };
+[kind, cleaned]`
+	program := ast.Modify(parser.New(lexer.NewFile("panic.mk", input)).ParseProgram(), nilBoom)
+	if evaluated := Eval(program, object.NewEnvironment()); evaluated.Inspect() != "[InternalError, true]" {
+		t.Errorf("expected the panic caught and the defer run, got=%s", evaluated.Inspect())
+	}
+
+	program = ast.Modify(parser.New(lexer.NewFile("panic.mk", "fn f() {\n\tboom[0]\n}\nf()")).ParseProgram(), nilBoom)
+	errObj, ok := Eval(program, object.NewEnvironment()).(*object.Error)
+	if !ok || errObj.Kind != object.INTERNAL_ERROR {
+		t.Fatalf("expected an InternalError, got=%+v", errObj)
+	}
+	expected := []string{"at f (panic.mk:2:6)", "at <main> (panic.mk:4:2)"}
+	trace := errObj.Trace()
+	if len(trace) != len(expected) {
+		t.Fatalf("trace has wrong length. got=%q", trace)
+	}
+	for i, line := range expected {
+		if trace[i] != line {
+			t.Errorf("trace[%d] wrong. expected=%q, got=%q", i, line, trace[i])
+		}
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`let foobar = 8; quote(unquote(foobar) + foobar)`, `(8 + foobar)`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote("a") + unquote(null))`, `(a + null)`},
+		{`let q = quote(4 + 4); quote(unquote(q) * 2)`, `((4 + 4) * 2)`},
+		{`quote(unquote([1, :ok]))`, `[1, :ok]`},
+		{`let f = fn(x) { quote(unquote(x) + 1) }; [f(1), f(2)]`, `[QUOTE((1 + 1)), QUOTE((2 + 1))]`},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if quote, ok := evaluated.(*object.Quote); ok {
+			if quote.Node.String() != tt.expected {
+				t.Errorf("%s: expected=%q, got=%q", tt.input, tt.expected, quote.Node.String())
+			}
+		} else if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: expected=%q, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	if err, ok := testEval(`quote(unquote(fn() { 1 }))`).(*object.Error); !ok || err.Inspect() != "TypeError: cannot unquote FUNCTION" {
+		t.Errorf("expected an error unquoting a function, got=%+v", err)
+	}
+	for _, input := range []string{"let x = 1;\nquote(unquote({\"a\": 1}))", "let x = 1;\nquote(unquote(1, 2))"} {
+		if err, ok := testEval(input).(*object.Error); !ok || err.Line != 2 || err.Column == 0 {
+			t.Errorf("%q: expected an error located at the unquote call, got=%+v", input, err)
+		}
+	}
+}
+
+func testExpand(input string) (*ast.Program, *object.Error) {
+	program := parser.New(lexer.New(input)).ParseProgram()
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	return ExpandMacros(program, env)
+}
+
+func TestMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let infixExpression = macro() { quote(1 + 2) }; infixExpression()`, `(1 + 2)`},
+		{`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)) }; reverse(2 + 2, 10 - 5)`, `(10 - 5) - (2 + 2)`},
+		{`let unless = macro(cond, cons, alt) {
+			quote(if (!(unquote(cond))) { unquote(cons) } else { unquote(alt) })
+		};
+		unless(10 > 5, puts("not greater"), puts("greater"))`, `if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`},
+		{`let twice = macro(x) { quote(unquote(x) + unquote(x)) }; [twice(1), twice(a)]`, `[(1 + 1), (a + a)]`},
+		{`let m = 1; m()`, `let m = 1;m()`},
+	}
+	for _, tt := range tests {
+		program, err := testExpand(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.input, err.Inspect())
+			continue
+		}
+		expected := parser.New(lexer.New(tt.expected)).ParseProgram()
+		if program.String() != expected.String() {
+			t.Errorf("%s: expected=%q, got=%q", tt.input, expected.String(), program.String())
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(x) { 1 }; m(2)`, "TypeError: macro must return a quote, got INTEGER"},
+		{`let m = macro(x) { quote(x) }; m()`, "ArgumentError: wrong number of arguments. got=0, want=1"},
+		{`let m = macro() { quote(unquote(nope)) }; m()`, "NameError: identifier not found: nope"},
+	}
+	for _, tt := range errors {
+		_, err := testExpand(tt.input)
+		if err == nil || err.Inspect() != tt.expected {
+			t.Errorf("%s: expected=%q, got=%+v", tt.input, tt.expected, err)
+		}
+	}
+
+	// Expanded code runs like any other
+	program, _ := testExpand(`let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };
+		[unless(1 > 2, "yes", "no"), unless(2 > 1, "yes", "no")]`)
+	if evaluated := Eval(program, object.NewEnvironment()); evaluated.Inspect() != "[yes, no]" {
+		t.Errorf("expected=%q, got=%q", "[yes, no]", evaluated.Inspect())
+	}
+	if evaluated := testEval(`let m = macro() { quote(1) }`); !isError(evaluated) {
+		t.Errorf("expected an error evaluating an unexpanded macro, got=%+v", evaluated)
+	}
+}
+
+func TestParseUnparseEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`parse("1 + 2")["node"]`, "Program"},
+		{`parse("1 + 2")["statements"][0]["expression"]["operator"]`, "+"},
+		{`let e = parse("x
+  * 2")["statements"][0]["expression"]; [e["line"], e["column"], e["token"]]`, "[2, 3, *]"},
+		{`parse("let x: int = 1;")["statements"][0]["type"]["name"]`, "int"},
+		{`parse("{1: 2}")["statements"][0]["expression"]["pairs"][0][1]["value"]`, 2},
+		{`parse("fn() {}")["statements"][0]["expression"]["return_type"]`, "null"},
+		{`parse("1 +")`, "SyntaxError: Expected a valid prefix for EOF"},
+		{`parse(1)`, "TypeError: argument to `parse` must be STRING, got INTEGER"},
+		{`unparse(parse("let x = 1 + 2; x"))`, "let x = (1 + 2);\nx"},
+		{`unparse(parse("fn f(a: int) -> int { a }"))`, "fn f(a: int) -> int {\n\ta\n}"},
+		{`unparse(quote("hi there"))`, `"hi there"`},
+		{`eval(unparse(quote(try { throw 1 } catch { 5 } finally { 2 })))`, 5},
+		{`eval(unparse(parse("let f = fn(a) { a * 2 }; f(4)")))`, 8},
+		{`unparse(quote(a * b))`, "(a * b)"},
+		{`let tree = parse("1 + 2"); let e = tree["statements"][0]["expression"];
+		  unparse({"node": "InfixExpression", "operator": "-", "left": e["right"], "right": e["left"]})`, "(2 - 1)"},
+		{`unparse({"node": "Widget"})`, "TypeError: malformed AST: unknown node type Widget"},
+		{`unparse({"statements": []})`, "TypeError: malformed AST: hash has no \"node\" key"},
+		{`let five = {"node": "IntegerLiteral", "value": 5};
+		  unparse({"node": "ExpressionStatement", "expression": {"node": "ReturnStatement", "return_value": five}})`,
+			"TypeError: malformed AST: expected ast.Expression, got *ast.ReturnStatement"},
+		{`unparse({"node": "InfixExpression"})`, "TypeError: malformed AST: InfixExpression has no left"},
+		{`unparse({"node": "LetStatement", "name": {"node": "Identifier", "value": "x"}})`, "TypeError: malformed AST: LetStatement has no value"},
+		{`eval({"node": "IfExpression", "consequence": {"node": "BlockStatement", "statements": []}})`,
+			"TypeError: malformed AST: IfExpression has no condition"},
+		{`eval({"node": "ReturnStatement", "return_value": null})`, "TypeError: malformed AST: ReturnStatement has no return_value"},
+		{`eval({"node": "ArrayLiteral", "elements": [null]})`, "TypeError: malformed AST: ArrayLiteral has a null in elements"},
+		{`unparse({"node": "IfExpression", "condition": {"node": "Boolean", "value": true},
+		  "consequence": {"node": "BlockStatement", "statements": []}})`, "if (true) {}"},
+		{`eval("1 + 2")`, 3},
+		{`eval("a * b", {"a": 6, "b": 7})`, 42},
+		{`eval(parse("let x = 20; x + 1"))`, 21},
+		{`eval(quote(10 - 3))`, 7},
+		{`eval({"node": "IntegerLiteral", "token": "5", "value": 5})`, 5},
+		{`eval("fn f(n) { if (n < 1) { 0 } else { n + f(n - 1) } } f(4)")`, 10},
+		{`let x = 1; eval("x")`, "NameError: identifier not found: x"},
+		{`let x = 1; eval("x = x + 1; x", {"x": x}) * 10 + x`, 21},
+		{`eval("x", {1: 2})`, "TypeError: binding names must be STRING, got INTEGER"},
+		{`eval("len([1, 2])")`, 2},
+		{`eval("return 4; 5")`, 4},
+		{`eval("let m = macro(a) { quote(unquote(a) * 2) }; m(21)")`, 42},
+		{`try { eval("throw 1") } catch (e) { e.kind }`, "Error"},
+		{`eval("1", 2)`, "TypeError: bindings for `eval` must be HASH, got INTEGER"},
+		{`eval("1 +")`, "SyntaxError: Expected a valid prefix for EOF"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	if err, ok := testEval("fn f() { eval(\"nope\") + 0 }\nf()").(*object.Error); !ok || len(err.Trace()) != 3 {
+		t.Errorf("expected an error w/ the eval call on its stack, got=%+v", err.Trace())
+	}
+}
+
+func TestUserOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`infix <> (precedence: SUM) = fn(a, b) { a + ", " + b }; "a" <> "b" <> "c"`, "a, b, c"},
+		{`infix ** (precedence: PRODUCT, assoc: right) = fn(a, b) { if (b == 0) { 1 } else { a * (a ** (b - 1)) } };
+		  2 ** 3 ** 2`, 512},
+		{`infix -- (precedence: SUM) = fn(a, b) { a - b - 1 }; 10 -- 2 -- 3`, 3},
+		{`fn mod(a, b) { a - a / b * b } infix % (precedence: PRODUCT) = mod; 1 + 17 % 5`, 3},
+		{`infix <> (precedence: SUM) = fn(a, b) { a.x }; 1 <> 2`, "MemberError: no method x on INTEGER"},
+		{`infix <> (precedence: SUM) = 5; 1 <> 2`, "TypeError: not a function: INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	err, ok := testEval("infix <> (precedence: SUM) = fn(a, b) { a / b }\n1 <> 0").(*object.Error)
+	if !ok || err.Trace()[0] != "at <> (<input>:1:43)" || err.Trace()[1] != "at <main> (<input>:2:3)" {
+		t.Errorf("expected the operator on the stack, got=%+v", err)
+	}
+}
+
+type unlessExpression struct {
+	ast.Custom
+	Token       token.Token
+	Condition   ast.Expression
+	Consequence *ast.BlockStatement
+}
+
+func (ue *unlessExpression) TokenLiteral() string { return ue.Token.Literal }
+func (ue *unlessExpression) String() string {
+	return "unless " + ue.Condition.String() + " " + ue.Consequence.String()
+}
+
+var unlessExtension = parser.ExtensionFunc(func(p *parser.Parser) {
+	p.RegisterKeyword("unless", "UNLESS")
+	p.RegisterPrefix("UNLESS", func() ast.Expression {
+		ue := &unlessExpression{Token: p.CurToken()}
+		p.NextToken()
+		ue.Condition = p.ParseExpression(parser.LOWEST)
+		if !p.ExpectPeek(token.LBRACE) {
+			return nil
+		}
+		ue.Consequence = p.ParseBlockStatement()
+		return ue
+	})
+})
+
+// orphanExpression is a node type no evaluator is registered for
+type orphanExpression struct{ Token token.Token }
+
+func (oe *orphanExpression) TokenLiteral() string { return oe.Token.Literal }
+func (oe *orphanExpression) String() string       { return oe.Token.Literal }
+
+func TestCustomNodes(t *testing.T) {
+	RegisterNodeType(&unlessExpression{}, func(node ast.Node, env *object.Environment) object.Object {
+		ue := node.(*unlessExpression)
+		cond := Eval(ue.Condition, env)
+		if isAbrupt(cond) {
+			return cond
+		}
+		if isTruthy(cond) {
+			return NULL
+		}
+		return Eval(ue.Consequence, env)
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`unless 1 > 2 { 10 }`, 10},
+		{`unless 1 < 2 { 10 }`, "null"},
+		{`let f = fn(x) { unless x > 0 { return 0 - x } x }; f(-3) + f(4)`, 7},
+		{`unless y { 1 }`, "NameError: identifier not found: y"},
+	}
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input), parser.WithExtensions(unlessExtension))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+		evaluated := Eval(program, object.NewEnvironment())
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+
+	// registered types round-trip through AST data like built-in ones
+	p := parser.New(lexer.New(`unless x { 1 }`), parser.WithExtensions(unlessExtension))
+	program := p.ParseProgram()
+	node, err := ObjectToNode(NodeToObject(program))
+	if err != nil {
+		t.Fatalf("round trip failed: %s", err.Inspect())
+	}
+	if node.String() != program.String() {
+		t.Errorf("expected=%q, got=%q", program.String(), node.String())
+	}
+
+	orphan := &orphanExpression{Token: token.Token{Literal: "?", Line: 3, Column: 2}}
+	errObj, ok := Eval(orphan, object.NewEnvironment()).(*object.Error)
+	if !ok || errObj.Inspect() != "TypeError: no evaluator registered for node type *evaluator.orphanExpression" || errObj.Line != 3 {
+		t.Errorf("expected an error for an unregistered node type, got=%+v", errObj)
+	}
+}
@@ -0,0 +1,42 @@
+package evaluator
+
+import (
+	"lang/ast"
+	"lang/object"
+	"reflect"
+)
+
+// NodeEvaluator evaluates a node of a type added by a parser extension. It
+// can call Eval on the node's children
+type NodeEvaluator func(node ast.Node, env *object.Environment) object.Object
+
+var nodeEvaluators = map[reflect.Type]NodeEvaluator{}
+
+// RegisterNodeType has Eval run nodes of node's type w/ eval, e.g.
+// `RegisterNodeType(&UnlessExpression{}, evalUnless)`. The type also becomes
+// known to parse, unparse and eval under its name, so it can't share one w/
+// an ast node type. Register types before evaluating anything, typically
+// from an init function
+func RegisterNodeType(node ast.Node, eval NodeEvaluator) {
+	t := reflect.TypeOf(node)
+	name := t.Elem().Name()
+	if existing, ok := nodeTypes[name]; ok && existing != t.Elem() {
+		panic("evaluator: node type " + name + " registered twice")
+	}
+	nodeTypes[name] = t.Elem()
+	nodeEvaluators[t] = eval
+}
+
+// evalCustomNode runs the evaluator registered for node's type. A node
+// nobody registered is an error rather than a silent null, e.g. a parser
+// extension whose evaluator was never added
+func evalCustomNode(node ast.Node, env *object.Environment) object.Object {
+	if node == nil {
+		return nil
+	}
+	if eval, ok := nodeEvaluators[reflect.TypeOf(node)]; ok {
+		return eval(node, env)
+	}
+	err := newError(object.TYPE_ERROR, "no evaluator registered for node type %T", node)
+	return locate(err, nodeToken(node), env)
+}
@@ -0,0 +1,296 @@
+package evaluator
+
+import (
+	"lang/ast"
+	"lang/object"
+	"runtime"
+)
+
+// stopGenerator unwinds the goroutine of a generator that was garbage
+// collected while suspended at a yield
+type stopGenerator struct{}
+
+// newGenerator starts a paused call to a generator function. The body runs on
+// its own goroutine, but only ever while the consumer is blocked in Next, so
+// the two never touch the interpreter state at the same time
+func newGenerator(fn *object.Function, args []object.Object, frame *object.Frame) *object.Generator {
+	yields := make(chan object.Object)
+	resume := make(chan struct{})
+	stop := make(chan struct{})
+	var result object.Object
+
+	run := func() {
+		defer close(yields)
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(stopGenerator); !ok {
+					result = newError(object.INTERNAL_ERROR, "internal error: %v", r)
+				}
+			}
+		}()
+
+		frame.Yield = func(val object.Object) {
+			select {
+			case yields <- val:
+			case <-stop:
+				panic(stopGenerator{})
+			}
+			select {
+			case <-resume:
+			case <-stop:
+				panic(stopGenerator{})
+			}
+		}
+		if res := finishTailCalls(evalFunctionBody(fn, args, frame)); isError(res) {
+			result = res
+		}
+	}
+
+	started, done := false, false
+	gen := &object.Generator{Name: fn.Name}
+	gen.Next = func() (object.Object, bool) {
+		if done {
+			return nil, false
+		}
+		if started {
+			resume <- struct{}{}
+		} else {
+			started = true
+			go run()
+		}
+		if val, ok := <-yields; ok {
+			return val, true
+		}
+		done = true
+		return result, false
+	}
+	// Neither closure refers to gen, so an abandoned generator does get
+	// collected, and its goroutine w/ it
+	runtime.SetFinalizer(gen, func(*object.Generator) { close(stop) })
+	return gen
+}
+
+func evalYield(val object.Object, env *object.Environment) object.Object {
+	frame := env.Frame()
+	if frame == nil || frame.Yield == nil {
+		return newError(object.ERROR, "yield outside generator")
+	}
+	frame.Yield(val)
+	return NULL
+}
+
+func evalForExpression(node *ast.ForExpression, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	next, err := iterate(iterable)
+	if err != nil {
+		return err
+	}
+
+	for {
+		val, ok := next()
+		if !ok {
+			if isError(val) {
+				return val
+			}
+			return NULL
+		}
+		loopEnv := object.NewEnclosedEnvironment(env)
+		loopEnv.Set(node.Variable.Value, val)
+		if res := evalBlockStatements(node.Body, loopEnv); isAbrupt(res) {
+			return res
+		}
+	}
+}
+
+// iterate returns a function stepping through the elements of obj the same
+// way Generator.Next does: arrays and strings in order, hashes by key
+func iterate(obj object.Object) (func() (object.Object, bool), object.Object) {
+	var elements []object.Object
+	switch obj := obj.(type) {
+	case *object.Generator:
+		return obj.Next, nil
+	case *object.Array:
+		elements = obj.Elements
+	case *object.String:
+		for ix := 0; ix < len(obj.Value); ix++ {
+			elements = append(elements, &object.String{Value: string(obj.Value[ix])})
+		}
+	case *object.Hash:
+		for _, pair := range sortedPairs(obj) {
+			elements = append(elements, pair.Key)
+		}
+	default:
+		return nil, newError(object.TYPE_ERROR, "not iterable: %s", object.TypeName(obj))
+	}
+
+	ix := 0
+	return func() (object.Object, bool) {
+		if ix >= len(elements) {
+			return nil, false
+		}
+		ix++
+		return elements[ix-1], true
+	}, nil
+}
+
+func init() {
+	builtins["next"] = &object.Builtin{Fn: generatorNext}
+	builtins["range"] = &object.Builtin{Fn: rangeGenerator}
+	builtins["iter"] = &object.Builtin{Fn: iterGenerator}
+
+	RegisterMethod(object.GENERATOR_OBJ, "next", generatorNext)
+	addMethod(object.GENERATOR_OBJ, "map", &object.Builtin{FrameFn: mapValues})
+	addMethod(object.GENERATOR_OBJ, "filter", &object.Builtin{FrameFn: generatorFilter})
+	RegisterMethod(object.GENERATOR_OBJ, "take", generatorTake)
+	RegisterMethod(object.GENERATOR_OBJ, "collect", generatorCollect)
+}
+
+// generatorNext resumes a generator, returning Some(value) for the next
+// yielded value or None once it's finished
+func generatorNext(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	gen, ok := args[0].(*object.Generator)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `next` not supported, got %s", object.TypeName(args[0]))
+	}
+	val, ok := gen.Next()
+	if !ok {
+		if isError(val) {
+			return val
+		}
+		return NONE
+	}
+	return newSome(val)
+}
+
+// iterGenerator wraps anything a for loop can walk in a generator, so the
+// prelude can reach the Go-backed filter/collect for any iterable
+func iterGenerator(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if gen, ok := args[0].(*object.Generator); ok {
+		return gen
+	}
+	next, err := iterate(args[0])
+	if err != nil {
+		return err
+	}
+	return &object.Generator{Name: "iter", Next: next}
+}
+
+// rangeGenerator lazily counts from start (0 by default) up to, but not
+// including, end
+func rangeGenerator(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	bounds := []int64{0}
+	for _, arg := range args {
+		n, ok := arg.(*object.Integer)
+		if !ok {
+			return newError(object.TYPE_ERROR, "argument to `range` not supported, got %s", object.TypeName(arg))
+		}
+		bounds = append(bounds, n.Value)
+	}
+	next, end := bounds[len(bounds)-2], bounds[len(bounds)-1]
+	return &object.Generator{Name: "range", Next: func() (object.Object, bool) {
+		if next >= end {
+			return nil, false
+		}
+		next++
+		return &object.Integer{Value: next - 1}, true
+	}}
+}
+
+// stage builds a lazy generator on top of src. step gets each value src
+// yields and returns what to pass on, whether to pass anything at all, and
+// any error, which ends the stage
+func stage(src *object.Generator, step func(val object.Object) (object.Object, bool, object.Object)) *object.Generator {
+	done := false
+	return &object.Generator{Name: src.Name, Next: func() (object.Object, bool) {
+		for !done {
+			val, ok := src.Next()
+			if !ok {
+				done = true
+				return val, false
+			}
+			out, keep, err := step(val)
+			if err != nil {
+				done = true
+				return err, false
+			}
+			if keep {
+				return out, true
+			}
+		}
+		return nil, false
+	}}
+}
+
+func mapGenerator(frame *object.Frame, src *object.Generator, fn object.Object) *object.Generator {
+	return stage(src, func(val object.Object) (object.Object, bool, object.Object) {
+		res := callback(frame, fn, val)
+		if isError(res) {
+			return nil, false, res
+		}
+		return res, true, nil
+	})
+}
+
+func generatorFilter(frame *object.Frame, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args)-1)
+	}
+	fn := args[1]
+	return stage(args[0].(*object.Generator), func(val object.Object) (object.Object, bool, object.Object) {
+		res := callback(frame, fn, val)
+		if isError(res) {
+			return nil, false, res
+		}
+		return val, isTruthy(res), nil
+	})
+}
+
+func generatorTake(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args)-1)
+	}
+	n, ok := args[1].(*object.Integer)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `take` not supported, got %s", object.TypeName(args[1]))
+	}
+	src := args[0].(*object.Generator)
+	taken := int64(0)
+	return &object.Generator{Name: src.Name, Next: func() (object.Object, bool) {
+		// Check the count first so the source isn't resumed past the end
+		if taken >= n.Value {
+			return nil, false
+		}
+		taken++
+		return src.Next()
+	}}
+}
+
+func generatorCollect(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=0", len(args)-1)
+	}
+	elements := []object.Object{}
+	next := args[0].(*object.Generator).Next
+	for {
+		val, ok := next()
+		if !ok {
+			if isError(val) {
+				return val
+			}
+			return &object.Array{Elements: elements}
+		}
+		elements = append(elements, val)
+	}
+}
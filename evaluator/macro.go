@@ -0,0 +1,167 @@
+package evaluator
+
+import (
+	"fmt"
+	"lang/ast"
+	"lang/object"
+	"lang/token"
+)
+
+// quote returns node unevaluated, except for the `unquote(...)` calls inside
+// it: those are evaluated in env and their values spliced back in as code
+func quote(node ast.Node, env *object.Environment) object.Object {
+	var failed object.Object
+	node = ast.Modify(node, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || failed != nil || !isCallTo(call, "unquote") {
+			return node
+		}
+		if len(call.Arguments) != 1 {
+			failed = locate(newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(call.Arguments)), call.Token, env)
+			return node
+		}
+
+		val := Eval(call.Arguments[0], env)
+		if isAbrupt(val) {
+			failed = val
+			return node
+		}
+		expr, err := objectToExpression(val, call.Token)
+		if err != nil {
+			failed = locate(err, call.Token, env)
+			return node
+		}
+		return expr
+	})
+
+	if failed != nil {
+		return failed
+	}
+	return &object.Quote{Node: node}
+}
+
+func isCallTo(call *ast.CallExpression, name string) bool {
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+// objectToExpression turns the value of an unquote back into code. The new
+// nodes take their position from tok, the unquote call
+func objectToExpression(obj object.Object, tok token.Token) (ast.Expression, *object.Error) {
+	at := func(tt token.TokenType, literal string) token.Token {
+		return token.Token{Type: tt, Literal: literal, File: tok.File, Line: tok.Line, Column: tok.Column}
+	}
+
+	switch obj := obj.(type) {
+	case *object.Quote:
+		if expr, ok := obj.Node.(ast.Expression); ok {
+			return expr, nil
+		}
+	case *object.Integer:
+		return &ast.IntegerLiteral{Token: at(token.INT, fmt.Sprintf("%d", obj.Value)), Value: obj.Value}, nil
+	case *object.Boolean:
+		if obj.Value {
+			return &ast.Boolean{Token: at(token.TRUE, "true"), Value: true}, nil
+		}
+		return &ast.Boolean{Token: at(token.FALSE, "false"), Value: false}, nil
+	case *object.String:
+		return &ast.StringLiteral{Token: at(token.STRING, obj.Value), Value: obj.Value}, nil
+	case *object.Null:
+		return &ast.NullLiteral{Token: at(token.NULL, "null")}, nil
+	case *object.Symbol:
+		return &ast.SymbolLiteral{Token: at(token.SYMBOL, obj.Name), Value: obj.Name}, nil
+	case *object.Array:
+		array := &ast.ArrayLiteral{Token: at(token.LBRACKET, "[")}
+		for _, el := range obj.Elements {
+			expr, err := objectToExpression(el, tok)
+			if err != nil {
+				return nil, err
+			}
+			array.Elements = append(array.Elements, expr)
+		}
+		return array, nil
+	}
+	return nil, newError(object.TYPE_ERROR, "cannot unquote %s", object.TypeName(obj))
+}
+
+// DefineMacros removes the `let name = macro(...) {...}` statements from the
+// top level of program and binds the macros they define in env. Run it, then
+// ExpandMacros w/ the same env, before evaluating the program
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	stmts := []ast.Statement{}
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		ml, ok := let.Value.(*ast.MacroLiteral)
+		if !ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		env.Set(let.Name.Value, &object.Macro{Parameters: ml.Parameters, Body: ml.Body, Env: env})
+	}
+	program.Statements = stmts
+}
+
+// ExpandMacros replaces every call to a macro defined in env w/ the code the
+// macro returns. Macro bodies run at expansion time w/ their arguments bound
+// as quotes of the unevaluated argument expressions
+func ExpandMacros(program *ast.Program, env *object.Environment) (*ast.Program, *object.Error) {
+	var failed *object.Error
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || failed != nil {
+			return node
+		}
+		macro, ok := macroOf(call, env)
+		if !ok {
+			return node
+		}
+
+		quoted, err := expandMacro(macro, call)
+		if err != nil {
+			failed = locate(err, call.Token, env).(*object.Error)
+			return node
+		}
+		return quoted.Node
+	})
+	return expanded.(*ast.Program), failed
+}
+
+func macroOf(call *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	obj, ok := env.Get(ident.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func expandMacro(macro *object.Macro, call *ast.CallExpression) (*object.Quote, *object.Error) {
+	if len(call.Arguments) != len(macro.Parameters) {
+		return nil, newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d",
+			len(call.Arguments), len(macro.Parameters))
+	}
+
+	env := object.NewEnclosedEnvironment(macro.Env)
+	for ix, param := range macro.Parameters {
+		env.Set(param.Value, &object.Quote{Node: call.Arguments[ix]})
+	}
+
+	switch result := unwrapReturnValue(Eval(macro.Body, env)).(type) {
+	case *object.Error:
+		return nil, result
+	case *object.Quote:
+		return result, nil
+	case nil:
+		return nil, newError(object.TYPE_ERROR, "macro must return a quote, got nothing")
+	default:
+		return nil, newError(object.TYPE_ERROR, "macro must return a quote, got %s", object.TypeName(result))
+	}
+}
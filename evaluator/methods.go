@@ -0,0 +1,204 @@
+package evaluator
+
+import (
+	"lang/object"
+	"sort"
+	"strings"
+)
+
+// methods holds the methods available through `recv.name(...)` on builtin
+// types. A method is an ordinary builtin that gets the receiver as its first
+// argument, so most entries simply reuse the free function of the same name
+var methods = map[object.ObjectType]map[string]*object.Builtin{}
+
+// RegisterMethod adds (or replaces) a method on a builtin type
+func RegisterMethod(t object.ObjectType, name string, fn object.BuiltinFunction) {
+	addMethod(t, name, &object.Builtin{Fn: fn})
+}
+
+func addMethod(t object.ObjectType, name string, method *object.Builtin) {
+	if methods[t] == nil {
+		methods[t] = make(map[string]*object.Builtin)
+	}
+	methods[t][name] = method
+}
+
+func init() {
+	RegisterMethod(object.STRING_OBJ, "len", builtins["len"].Fn)
+	RegisterMethod(object.STRING_OBJ, "upper", stringMethod(strings.ToUpper))
+	RegisterMethod(object.STRING_OBJ, "lower", stringMethod(strings.ToLower))
+	RegisterMethod(object.STRING_OBJ, "trim", stringMethod(strings.TrimSpace))
+	RegisterMethod(object.STRING_OBJ, "split", stringSplit)
+	RegisterMethod(object.STRING_OBJ, "contains", stringContains)
+
+	RegisterMethod(object.ARRAY_OBJ, "len", builtins["len"].Fn)
+	RegisterMethod(object.ARRAY_OBJ, "first", builtins["first"].Fn)
+	RegisterMethod(object.ARRAY_OBJ, "rest", builtins["rest"].Fn)
+	RegisterMethod(object.ARRAY_OBJ, "push", builtins["push"].Fn)
+	addMethod(object.ARRAY_OBJ, "map", &object.Builtin{FrameFn: mapValues})
+	addMethod(object.ARRAY_OBJ, "filter", &object.Builtin{FrameFn: arrayFilter})
+	addMethod(object.ARRAY_OBJ, "reduce", &object.Builtin{FrameFn: arrayReduce})
+	RegisterMethod(object.ARRAY_OBJ, "join", arrayJoin)
+
+	RegisterMethod(object.HASH_OBJ, "keys", hashKeys)
+	RegisterMethod(object.HASH_OBJ, "values", hashValues)
+	RegisterMethod(object.HASH_OBJ, "has", hashHas)
+
+	for _, t := range []object.ObjectType{"Option", "Result"} {
+		RegisterMethod(t, "unwrap", unwrap)
+		RegisterMethod(t, "unwrap_or", unwrapOr)
+		addMethod(t, "map", &object.Builtin{FrameFn: mapValues})
+	}
+	RegisterMethod("Option", "is_some", isVariant(SOME).Fn)
+	RegisterMethod("Option", "is_none", isVariant(NONE.Variant).Fn)
+	RegisterMethod("Result", "is_ok", isVariant(OK).Fn)
+	RegisterMethod("Result", "is_err", isVariant(ERR).Fn)
+}
+
+// lookupMethod finds a builtin method for obj, binding obj as the receiver
+func lookupMethod(obj object.Object, name string) object.Object {
+	method, ok := methods[methodType(obj)][name]
+	if !ok {
+		return newError(object.MEMBER_ERROR, "no method %s on %s", name, object.TypeName(obj))
+	}
+	return &object.BoundMethod{Receiver: obj, Name: name, Method: method}
+}
+
+// methodType is the key of obj's methods in the table: its ObjectType, except
+// for Option and Result values, whose methods are under the enum's name. A
+// user enum w/ the same name is a different enum, and gets none of them
+func methodType(obj object.Object) object.ObjectType {
+	if ev, ok := obj.(*object.EnumValue); ok && (ev.Variant.Enum == optionEnum || ev.Variant.Enum == resultEnum) {
+		return object.ObjectType(ev.Variant.Enum.Name)
+	}
+	return obj.Type()
+}
+
+func stringMethod(fn func(string) string) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=0", len(args)-1)
+		}
+		return &object.String{Value: fn(args[0].(*object.String).Value)}
+	}
+}
+
+func stringSplit(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args)-1)
+	}
+	sep, ok := args[1].(*object.String)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `split` not supported, got %s", object.TypeName(args[1]))
+	}
+	parts := strings.Split(args[0].(*object.String).Value, sep.Value)
+	elements := make([]object.Object, 0, len(parts))
+	for _, part := range parts {
+		elements = append(elements, &object.String{Value: part})
+	}
+	return &object.Array{Elements: elements}
+}
+
+func stringContains(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args)-1)
+	}
+	sub, ok := args[1].(*object.String)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `contains` not supported, got %s", object.TypeName(args[1]))
+	}
+	return getGlobalBool(strings.Contains(args[0].(*object.String).Value, sub.Value))
+}
+
+func arrayFilter(frame *object.Frame, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args)-1)
+	}
+	kept := []object.Object{}
+	for _, el := range args[0].(*object.Array).Elements {
+		res := callback(frame, args[1], el)
+		if isError(res) {
+			return res
+		}
+		if isTruthy(res) {
+			kept = append(kept, el)
+		}
+	}
+	return &object.Array{Elements: kept}
+}
+
+func arrayReduce(frame *object.Frame, args ...object.Object) object.Object {
+	if len(args) != 3 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args)-1)
+	}
+	acc := args[1]
+	for _, el := range args[0].(*object.Array).Elements {
+		acc = callback(frame, args[2], acc, el)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func arrayJoin(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args)-1)
+	}
+	sep, ok := args[1].(*object.String)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `join` not supported, got %s", object.TypeName(args[1]))
+	}
+	parts := []string{}
+	for _, el := range args[0].(*object.Array).Elements {
+		parts = append(parts, el.Inspect())
+	}
+	return &object.String{Value: strings.Join(parts, sep.Value)}
+}
+
+// sortedPairs returns a hash's pairs in a stable order (by inspected key) so
+// keys() and values() don't depend on Go's map iteration order
+func sortedPairs(hash *object.Hash) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(hash.Pairs))
+	for _, pair := range hash.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+	})
+	return pairs
+}
+
+func hashKeys(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=0", len(args)-1)
+	}
+	keys := []object.Object{}
+	for _, pair := range sortedPairs(args[0].(*object.Hash)) {
+		keys = append(keys, pair.Key)
+	}
+	return &object.Array{Elements: keys}
+}
+
+func hashValues(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=0", len(args)-1)
+	}
+	values := []object.Object{}
+	for _, pair := range sortedPairs(args[0].(*object.Hash)) {
+		values = append(values, pair.Value)
+	}
+	return &object.Array{Elements: values}
+}
+
+func hashHas(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args)-1)
+	}
+	key, ok := args[1].(object.Hashable)
+	if !ok {
+		return newError(object.TYPE_ERROR, "unusable as hash key: %s", object.TypeName(args[1]))
+	}
+	_, found := args[0].(*object.Hash).Pairs[key.HashKey()]
+	return getGlobalBool(found)
+}
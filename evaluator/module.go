@@ -0,0 +1,248 @@
+package evaluator
+
+import (
+	"lang/ast"
+	"lang/object"
+	"lang/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ModuleExt is the extension tried when an import path doesn't have one,
+// before the indentation syntax's parser.IndentedExt
+const ModuleExt = ".mk"
+
+// PathEnv names the environment variable listing extra directories to search
+// for modules, after the directory of the importing file
+const PathEnv = "LANG_PATH"
+
+// A module is loaded once per canonical path. Until it's done loading, other
+// tasks importing it wait on ready
+type moduleEntry struct {
+	ready  chan struct{}
+	module *object.Module
+	err    *object.Error
+	// While it's loading: the chain of imports that led to it, itself last,
+	// and the module its evaluation is importing right now, "" if none
+	loading   bool
+	chain     []string
+	importing string
+}
+
+var modules = struct {
+	sync.Mutex
+	cache map[string]*moduleEntry
+}{cache: make(map[string]*moduleEntry)}
+
+// ScriptEnvironment returns the environment to run the script at path in.
+// The script counts as the root of its import chain, so a module importing it
+// back is reported as a cycle
+func ScriptEnvironment(path string) *object.Environment {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		canonical = path
+	}
+	return object.NewModuleEnvironment(&object.Module{Name: moduleName(path), Path: canonical})
+}
+
+func evalImportStatement(node *ast.ImportStatement, env *object.Environment) object.Object {
+	path, err := resolveModule(node.Path, node.Token.File)
+	if err != nil {
+		return err
+	}
+	module, err := loadModule(path, importChain(env), env.Options())
+	if err != nil {
+		return err
+	}
+
+	name := moduleName(node.Path)
+	if node.Alias != nil {
+		name = node.Alias.Value
+	}
+	return declare(env, name, module, false)
+}
+
+// resolveModule finds the file an import refers to: relative paths are looked
+// up next to the importing file (or in the working directory, for the REPL),
+// then in each directory on LANG_PATH
+func resolveModule(path, importer string) (string, *object.Error) {
+	candidates := []string{path}
+	if filepath.Ext(path) == "" {
+		candidates = append(candidates, path+ModuleExt, path+parser.IndentedExt)
+	}
+
+	dirs := []string{""}
+	if !filepath.IsAbs(path) {
+		dirs = []string{filepath.Dir(importer)}
+		for _, dir := range filepath.SplitList(os.Getenv(PathEnv)) {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		for _, candidate := range candidates {
+			full := filepath.Join(dir, candidate)
+			if info, err := os.Stat(full); err == nil && !info.IsDir() {
+				canonical, err := canonicalPath(full)
+				if err != nil {
+					return "", newError(object.IMPORT_ERROR, "%s", err)
+				}
+				return canonical, nil
+			}
+		}
+	}
+	return "", newError(object.IMPORT_ERROR, "module not found: %s", path)
+}
+
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+func moduleName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// importChain returns the imports in progress in the task running code in
+// env, outermost first: the chain that led to loading the module whose top
+// level it runs under. Code eval runs counts as running where the outermost
+// call on its stack was made, usually the top level that called eval
+func importChain(env *object.Environment) []string {
+	for frame := env.Frame(); frame != nil; frame = env.Frame() {
+		for frame.Parent != nil {
+			frame = frame.Parent
+		}
+		if frame.Env == nil {
+			break
+		}
+		env = frame.Env
+	}
+	module := env.Module()
+	if module == nil {
+		return nil
+	}
+	modules.Lock()
+	defer modules.Unlock()
+	if entry, ok := modules.cache[module.Path]; ok && entry.loading {
+		return entry.chain
+	}
+	return []string{module.Path}
+}
+
+// loadModule returns the module at the canonical path, evaluating it first
+// if this is the first import of it. chain is the importChain of the import;
+// the first import's options are the ones the module runs w/
+func loadModule(path string, chain []string, opts object.Options) (*object.Module, *object.Error) {
+	modules.Lock()
+	if cycle := importCycle(path, chain); cycle != nil {
+		modules.Unlock()
+		names := []string{}
+		for _, p := range cycle {
+			names = append(names, filepath.Base(p))
+		}
+		return nil, newError(object.IMPORT_ERROR, "import cycle: %s", strings.Join(names, " -> "))
+	}
+	var importer *moduleEntry
+	if len(chain) > 0 {
+		if e, ok := modules.cache[chain[len(chain)-1]]; ok && e.loading {
+			importer = e
+			importer.importing = path
+		}
+	}
+	entry, ok := modules.cache[path]
+	if !ok {
+		entry = &moduleEntry{ready: make(chan struct{}), loading: true}
+		entry.chain = append(chain[:len(chain):len(chain)], path)
+		modules.cache[path] = entry
+	}
+	modules.Unlock()
+
+	if ok {
+		<-entry.ready
+	} else {
+		entry.module, entry.err = evalModule(path, opts)
+	}
+
+	modules.Lock()
+	if importer != nil {
+		importer.importing = ""
+	}
+	if !ok {
+		entry.loading = false
+		if entry.err != nil {
+			// Leave the next import free to try again, e.g. once the file's
+			// fixed
+			delete(modules.cache, path)
+		}
+		close(entry.ready)
+	}
+	modules.Unlock()
+	return entry.module, entry.err
+}
+
+// importCycle returns the cycle importing path at the end of chain would
+// close, nil if there's none. That's path being on the chain already, or
+// path still loading in another task that's waiting, through the modules it
+// in turn is importing, on one that is. Waiting for it would never end.
+// Call w/ modules locked
+func importCycle(path string, chain []string) []string {
+	trail := []string{path}
+	for p := path; ; {
+		for ix, q := range chain {
+			if q == p {
+				return append(chain[ix:len(chain):len(chain)], trail...)
+			}
+		}
+		entry, ok := modules.cache[p]
+		if !ok || !entry.loading || entry.importing == "" || len(trail) > len(modules.cache) {
+			return nil
+		}
+		p = entry.importing
+		trail = append(trail, p)
+	}
+}
+
+func evalModule(path string, opts object.Options) (*object.Module, *object.Error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, newError(object.IMPORT_ERROR, "%s", err)
+	}
+
+	p := parser.NewFile(path, string(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, newError(object.IMPORT_ERROR, "parse errors in %s: %s", path, strings.Join(p.Errors(), "; "))
+	}
+	macroEnv := object.NewEnvironment()
+	DefineMacros(program, macroEnv)
+	program, expandErr := ExpandMacros(program, macroEnv)
+	if expandErr != nil {
+		return nil, expandErr
+	}
+
+	module := &object.Module{
+		Name:    moduleName(path),
+		Path:    path,
+		Exports: make(map[string]bool),
+	}
+	for _, stmt := range program.Statements {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			module.Exports[export.ExportedName()] = true
+		}
+	}
+	module.Env = object.NewModuleEnvironment(module)
+	module.Env.SetOptions(opts)
+
+	if err, ok := Eval(program, module.Env).(*object.Error); ok {
+		return nil, err
+	}
+	return module, nil
+}
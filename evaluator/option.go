@@ -0,0 +1,149 @@
+package evaluator
+
+import "lang/object"
+
+// Option and Result are ordinary enums that happen to be predeclared, so they
+// work w/ match patterns (Some(x), None, ...) like any user enum
+var (
+	optionEnum = &object.EnumType{Name: "Option"}
+	SOME       = optionEnum.AddVariant("Some", "value")
+	NONE       = optionEnum.AddVariant("None").Unit
+
+	resultEnum = &object.EnumType{Name: "Result"}
+	OK         = resultEnum.AddVariant("Ok", "value")
+	ERR        = resultEnum.AddVariant("Err", "error")
+)
+
+var builtinValues = map[string]object.Object{
+	"Option": optionEnum,
+	"Some":   SOME,
+	"None":   NONE,
+	"Result": resultEnum,
+	"Ok":     OK,
+	"Err":    ERR,
+}
+
+func newSome(val object.Object) *object.EnumValue {
+	return &object.EnumValue{Variant: SOME, Values: []object.Object{val}}
+}
+
+// unwrapped returns the payload of Some/Ok. ok is false for None/Err, and
+// isOption is false if obj is neither an Option nor a Result
+func unwrapped(obj object.Object) (val object.Object, ok bool, isOption bool) {
+	ev, isEnum := obj.(*object.EnumValue)
+	if !isEnum || (ev.Variant.Enum != optionEnum && ev.Variant.Enum != resultEnum) {
+		return nil, false, false
+	}
+	switch ev.Variant {
+	case SOME, OK:
+		return ev.Values[0], true, true
+	default:
+		return nil, false, true
+	}
+}
+
+// evalPropagateExpression implements `expr?`: Some/Ok unwrap to their
+// payload, None/Err become the return value of the enclosing function
+func evalPropagateExpression(val object.Object) object.Object {
+	inner, ok, isOption := unwrapped(val)
+	if !isOption {
+		return newError(object.OPERATOR_ERROR, "operator ? not supported: %s", object.TypeName(val))
+	}
+	if !ok {
+		return &object.ReturnValue{Value: val}
+	}
+	return inner
+}
+
+func isVariant(v *object.Variant) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			ev, ok := args[0].(*object.EnumValue)
+			return getGlobalBool(ok && ev.Variant == v)
+		},
+	}
+}
+
+func init() {
+	builtins["is_some"] = isVariant(SOME)
+	builtins["is_none"] = isVariant(NONE.Variant)
+	builtins["is_ok"] = isVariant(OK)
+	builtins["is_err"] = isVariant(ERR)
+	builtins["unwrap"] = &object.Builtin{Fn: unwrap}
+	builtins["unwrap_or"] = &object.Builtin{Fn: unwrapOr}
+	builtins["map"] = &object.Builtin{FrameFn: mapValues}
+}
+
+func unwrap(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+	val, ok, isOption := unwrapped(args[0])
+	if !isOption {
+		return newError(object.TYPE_ERROR, "argument to `unwrap` not supported, got %s", object.TypeName(args[0]))
+	}
+	if !ok {
+		return newError(object.UNWRAP_ERROR, "called `unwrap` on %s", args[0].Inspect())
+	}
+	return val
+}
+
+func unwrapOr(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+	val, ok, isOption := unwrapped(args[0])
+	if !isOption {
+		return newError(object.TYPE_ERROR, "argument to `unwrap_or` not supported, got %s", object.TypeName(args[0]))
+	}
+	if !ok {
+		return args[1]
+	}
+	return val
+}
+
+// mapValues applies a function to every element of an array or generator, or
+// to the payload of Some/Ok. It's registered in init rather than in the builtins
+// literal since calling back into applyFunction would be an initialization
+// cycle
+func mapValues(frame *object.Frame, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+	fn := args[1]
+
+	if gen, ok := args[0].(*object.Generator); ok {
+		// Lazy: fn only runs as values are pulled through
+		return mapGenerator(frame, gen, fn)
+	}
+
+	if arr, ok := args[0].(*object.Array); ok {
+		mapped := make([]object.Object, 0, len(arr.Elements))
+		for _, el := range arr.Elements {
+			res := callback(frame, fn, el)
+			if isError(res) {
+				return res
+			}
+			mapped = append(mapped, res)
+		}
+		return &object.Array{Elements: mapped}
+	}
+
+	val, ok, isOption := unwrapped(args[0])
+	if !isOption {
+		return newError(object.TYPE_ERROR, "argument to `map` not supported, got %s", object.TypeName(args[0]))
+	}
+	if !ok {
+		// None and Err pass through untouched
+		return args[0]
+	}
+	res := callback(frame, fn, val)
+	if isError(res) {
+		return res
+	}
+	variant := args[0].(*object.EnumValue).Variant
+	return &object.EnumValue{Variant: variant, Values: []object.Object{res}}
+}
@@ -0,0 +1,89 @@
+package evaluator
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"lang/lexer"
+	"lang/object"
+	"lang/parser"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The prelude is the part of the standard library written in the language
+// itself. It's evaluated into its own environment, which identifier lookup
+// falls back to after the program's own bindings and before the Go builtins,
+// so scripts can shadow any of it. The embedded files are loaded the first
+// time that happens, by which point every init has registered the builtins
+// they're written in terms of
+//
+//go:embed prelude/*.mk
+var preludeFiles embed.FS
+
+// PreludeEnv names the environment variable pointing at a directory of .mk
+// files a project layers on top of the prelude, see LoadPrelude
+const PreludeEnv = "LANG_PRELUDE"
+
+var prelude = struct {
+	once   sync.Once
+	env    *object.Environment
+	module *object.Module // what the prelude's own code runs under
+}{module: &object.Module{Name: "prelude"}}
+
+func init() {
+	prelude.env = object.NewModuleEnvironment(prelude.module)
+}
+
+// lookupPrelude finds name in the prelude for code running in env, loading
+// the embedded prelude first if it hasn't been yet. The prelude's own code
+// finds its definitions through its environment instead, which also keeps
+// it from looking anything up here while it loads
+func lookupPrelude(name string, env *object.Environment) (object.Object, bool) {
+	if env.Module() == prelude.module {
+		return nil, false
+	}
+	prelude.once.Do(loadEmbeddedPrelude)
+	return prelude.env.Get(name)
+}
+
+func loadEmbeddedPrelude() {
+	sub, _ := fs.Sub(preludeFiles, "prelude")
+	if err := loadPrelude(sub); err != nil {
+		panic(err)
+	}
+}
+
+// LoadPrelude evaluates every .mk file at the root of fsys, in name order,
+// into the prelude. Whatever they define replaces earlier definitions of the
+// same name, e.g. the builtin prelude's
+func LoadPrelude(fsys fs.FS) error {
+	prelude.once.Do(loadEmbeddedPrelude)
+	return loadPrelude(fsys)
+}
+
+func loadPrelude(fsys fs.FS) error {
+	names, err := fs.Glob(fsys, "*.mk")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		src, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		p := parser.New(lexer.NewFile(path.Join("<prelude>", name), string(src)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return fmt.Errorf("prelude %s: %s", name, strings.Join(p.Errors(), "; "))
+		}
+		if err, ok := Eval(program, prelude.env).(*object.Error); ok {
+			return fmt.Errorf("prelude %s: %s", name, err.Inspect())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Functions over anything a for loop can walk: arrays, strings, hashes (by
+// key) and generators. They all run eagerly and return arrays; generators
+// have lazy map/filter/take methods for pipelines over large inputs.
+// filter and collect just hand off to those Go methods: push copies the
+// array, so building the result w/ a loop here would take quadratic time
+
+fn reduce(xs, init, f) {
+	let acc = init;
+	for (x in xs) { acc = f(acc, x) };
+	acc
+}
+
+fn filter(xs, keep) {
+	iter(xs).filter(keep).collect()
+}
+
+fn each(xs, f) {
+	for (x in xs) { f(x) };
+	null
+}
+
+fn collect(xs) {
+	iter(xs).collect()
+}
+
+fn sum(xs) {
+	reduce(xs, 0, fn(acc, x) { acc + x })
+}
+
+fn any(xs, test) {
+	for (x in xs) { if (test(x)) { return true } };
+	false
+}
+
+fn all(xs, test) {
+	!any(xs, fn(x) { !test(x) })
+}
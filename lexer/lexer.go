@@ -2,24 +2,54 @@ package lexer
 
 import (
 	"lang/token"
+	"sort"
+	"strings"
 )
 
 type Lexer struct {
 	input        string
 	position     int
-	readPosition int  // current reading position in input (lookahead)
-	ch           byte // current char under examination
+	readPosition int                        // current reading position in input (lookahead)
+	ch           byte                       // current char under examination
+	prev         token.TokenType            // type of the last token handed out
+	file         string                     // name stamped on every token
+	operators    []string                   // user-defined operators, longest first
+	keywords     map[string]token.TokenType // added on top of the builtin ones
+	line         int                        // position of ch in the input
+	column       int
+	// The indentation syntax: the widths of the enclosing indented blocks,
+	// nil when lexing braces. pending holds the layout tokens queued at a
+	// line break, and nesting counts open brackets, inside which line
+	// breaks don't count
+	indents []int
+	pending []token.Token
+	nesting int
+	// For each interpolation `${...}` being lexed, innermost last, the
+	// number of braces open inside it
+	templates []int
 }
 
 func New(inputStream string) *Lexer {
-	l := &Lexer{input: inputStream}
+	l := &Lexer{input: inputStream, line: 1}
 	l.readChar()
 	return l
 }
 
+// NewFile is New for input read from a named file
+func NewFile(name, inputStream string) *Lexer {
+	l := New(inputStream)
+	l.file = name
+	return l
+}
+
 func (l *Lexer) readChar() {
 	// 'Consumes' the current token -- gives char at current position
 	// advances position
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+	l.column += 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -30,8 +60,128 @@ func (l *Lexer) readChar() {
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	if l.indents != nil && len(l.pending) == 0 {
+		l.skipBlanks()
+		if l.ch == '\n' && l.nesting == 0 || l.ch == 0 {
+			l.lineBreak()
+		}
+	}
+	if len(l.pending) > 0 {
+		tok := l.pending[0]
+		l.pending = l.pending[1:]
+		l.prev = tok.Type
+		return tok
+	}
+
 	l.skipWhitespace()
+	line, column := l.line, l.column
+	tok := l.scanToken()
+	tok.File, tok.Line, tok.Column = l.file, line, column
+	switch tok.Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE, token.TEMPLATE_HEAD:
+		l.nesting++
+	case token.RPAREN, token.RBRACKET, token.RBRACE, token.TEMPLATE_TAIL:
+		if l.nesting > 0 {
+			l.nesting--
+		}
+	}
+	l.prev = tok.Type
+	return tok
+}
+
+// UseIndentation switches the lexer to the indentation syntax, where line
+// breaks outside brackets end statements (NEWLINE tokens) and changes in
+// indentation open and close blocks (INDENT and DEDENT tokens). Call it
+// before the first NextToken
+func (l *Lexer) UseIndentation() {
+	l.indents = []int{0}
+}
+
+// lineBreak queues the tokens a line break stands for in the indentation
+// syntax: a NEWLINE if the line had anything on it, then an INDENT or
+// DEDENTs if the next line w/ anything on it is indented more or less. At the
+// end of the input every open block is closed
+func (l *Lexer) lineBreak() {
+	switch l.prev {
+	case "", token.NEWLINE, token.INDENT, token.DEDENT, token.EOF:
+	default:
+		l.pending = append(l.pending, l.layoutToken(token.NEWLINE, ""))
+	}
+
+	width := l.indentation()
+	top := l.indents[len(l.indents)-1]
+	if width > top {
+		l.indents = append(l.indents, width)
+		l.pending = append(l.pending, l.layoutToken(token.INDENT, ""))
+		return
+	}
+	for width < top {
+		l.indents = l.indents[:len(l.indents)-1]
+		l.pending = append(l.pending, l.layoutToken(token.DEDENT, ""))
+		top = l.indents[len(l.indents)-1]
+	}
+	if width != top {
+		// Back out to a width no enclosing block has. Carry on as if it
+		// was the block's own, after the error
+		l.indents = append(l.indents, width)
+		l.pending = append(l.pending, l.layoutToken(token.ILLEGAL, "inconsistent indentation"))
+	}
+}
+
+// indentation skips to the start of the next line w/ anything on it besides
+// a comment, returning how far in that is. A tab indents to the next
+// multiple of 8. The end of the input counts as not indented
+func (l *Lexer) indentation() int {
+	width := 0
+	for {
+		switch {
+		case l.ch == '\n':
+			width = 0
+			l.readChar()
+		case l.ch == ' ':
+			width++
+			l.readChar()
+		case l.ch == '\t':
+			width += 8 - width%8
+			l.readChar()
+		case l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/':
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+		case l.ch == 0:
+			return 0
+		default:
+			return width
+		}
+	}
+}
+
+func (l *Lexer) layoutToken(tt token.TokenType, literal string) token.Token {
+	return token.Token{Type: tt, Literal: literal, File: l.file, Line: l.line, Column: l.column}
+}
+
+// AddOperator makes the lexer hand out op, e.g. `<>`, as a single token of
+// type TokenType(op). Declared operators take precedence over the builtin
+// ones they start w/, and the longest declared operator wins
+func (l *Lexer) AddOperator(op string) {
+	l.operators = append(l.operators, op)
+	sort.SliceStable(l.operators, func(i, j int) bool {
+		return len(l.operators[i]) > len(l.operators[j])
+	})
+}
+
+func (l *Lexer) scanToken() token.Token {
+	var tok token.Token
+	for _, op := range l.operators {
+		if l.position < len(l.input) && strings.HasPrefix(l.input[l.position:], op) {
+			for range op {
+				l.readChar()
+			}
+			return token.Token{Type: token.TokenType(op), Literal: op}
+		}
+	}
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -39,12 +189,25 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: literal}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.FAT_ARROW, Literal: literal}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
+		// `:name` is a symbol unless it follows something that can end an
+		// expression, in which case it's the colon of a hash pair
+		if isLetter(l.peekChar()) && !endsOperand(l.prev) {
+			l.readChar()
+			tok.Type = token.SYMBOL
+			tok.Literal = l.readIdentifier()
+			return tok
+		}
 		tok = newToken(token.COLON, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
@@ -56,10 +219,19 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RPAREN, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "->"}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
+	case '|':
+		tok = newToken(token.PIPE, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -73,24 +245,38 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.SLASH, l.ch)
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
+	case '?':
+		tok = newToken(token.QUESTION, l.ch)
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
 		tok = newToken(token.GT, l.ch)
 	case '{':
+		if n := len(l.templates); n > 0 {
+			l.templates[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		n := len(l.templates)
+		if n > 0 && l.templates[n-1] == 0 {
+			// The end of an interpolation: back to the string
+			l.templates = l.templates[:n-1]
+			tok = l.readStringPart(token.TEMPLATE_MIDDLE, token.TEMPLATE_TAIL)
+			break
+		}
+		if n > 0 {
+			l.templates[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readStringPart(token.TEMPLATE_HEAD, token.STRING)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
-			tok.Type = LookupIdentifierType(tok.Literal)
+			tok.Type = l.lookupIdentifierType(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Literal = l.readNumber()
@@ -112,15 +298,27 @@ func (l *Lexer) readNumber() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// readStringPart reads the text of a string from after its opening quote,
+// or the } ending an interpolation, up to the closing quote or the next `${`.
+// The token is of type interp if an interpolation follows, end otherwise, and
+// the lexer is left on the quote or the {. `\$` is the one escape: it
+// stands for a `$`, so `"\${x}"` is the text ${x}
+func (l *Lexer) readStringPart(interp, end token.TokenType) token.Token {
+	var literal strings.Builder
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch {
+		case l.ch == '"' || l.ch == 0:
+			return token.Token{Type: end, Literal: literal.String()}
+		case l.ch == '\\' && l.peekChar() == '$':
+			l.readChar()
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			l.templates = append(l.templates, 0)
+			return token.Token{Type: interp, Literal: literal.String()}
 		}
+		literal.WriteByte(l.ch)
 	}
-	return l.input[position:l.position]
 }
 
 func isDigit(ch byte) bool {
@@ -128,13 +326,51 @@ func isDigit(ch byte) bool {
 }
 
 var idents = map[string]token.TokenType{
-	"let":    token.LET,
-	"fn":     token.FUNCTION,
-	"true":   token.TRUE,
-	"false":  token.FALSE,
-	"if":     token.IF,
-	"else":   token.ELSE,
-	"return": token.RETURN,
+	"let":     token.LET,
+	"fn":      token.FUNCTION,
+	"true":    token.TRUE,
+	"false":   token.FALSE,
+	"if":      token.IF,
+	"else":    token.ELSE,
+	"return":  token.RETURN,
+	"null":    token.NULL,
+	"match":   token.MATCH,
+	"try":     token.TRY,
+	"catch":   token.CATCH,
+	"finally": token.FINALLY,
+	"throw":   token.THROW,
+	"defer":   token.DEFER,
+	"const":   token.CONST,
+	"yield":   token.YIELD,
+	"for":     token.FOR,
+	"in":      token.IN,
+	"spawn":   token.SPAWN,
+	"await":   token.AWAIT,
+	"select":  token.SELECT,
+	"import":  token.IMPORT,
+	"export":  token.EXPORT,
+	"as":      token.AS,
+	"struct":  token.STRUCT,
+	"impl":    token.IMPL,
+	"enum":    token.ENUM,
+	"macro":   token.MACRO,
+	"infix":   token.INFIX,
+}
+
+// AddKeyword makes the lexer hand out word as a token of type tt rather
+// than an identifier
+func (l *Lexer) AddKeyword(word string, tt token.TokenType) {
+	if l.keywords == nil {
+		l.keywords = make(map[string]token.TokenType)
+	}
+	l.keywords[word] = tt
+}
+
+func (l *Lexer) lookupIdentifierType(ident string) token.TokenType {
+	if tt, ok := l.keywords[ident]; ok {
+		return tt
+	}
+	return LookupIdentifierType(ident)
 }
 
 func LookupIdentifierType(ident string) token.TokenType {
@@ -160,12 +396,52 @@ func (l *Lexer) peekChar() byte {
 	}
 }
 
+// skipWhitespace skips blanks and `//` comments, which run to the end of the
+// line
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
-		l.readChar()
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/':
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+		default:
+			return
+		}
 	}
+}
 
+// skipBlanks is skipWhitespace for the indentation syntax, where a line
+// break outside brackets is a token
+func (l *Lexer) skipBlanks() {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' && l.nesting > 0:
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/':
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+		default:
+			return
+		}
+	}
 }
+
+// endsOperand reports whether a token of type tt can be the last token of an
+// operand, e.g. the `x` in `{x: 1}`
+func endsOperand(tt token.TokenType) bool {
+	switch tt {
+	case token.IDENT, token.INT, token.STRING, token.TEMPLATE_TAIL, token.SYMBOL,
+		token.TRUE, token.FALSE, token.NULL,
+		token.RPAREN, token.RBRACKET, token.RBRACE:
+		return true
+	}
+	return false
+}
+
 func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
@@ -133,3 +133,252 @@ func TestNextToken(t *testing.T) {
 
 	}
 }
+
+func TestSymbolsAndColons(t *testing.T) {
+	input := `{:ok: 1, x: :error} match null => :`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LBRACE, "{"},
+		{token.SYMBOL, "ok"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.IDENT, "x"},
+		{token.COLON, ":"},
+		{token.SYMBOL, "error"},
+		{token.RBRACE, "}"},
+		{token.MATCH, "match"},
+		{token.NULL, "null"},
+		{token.FAT_ARROW, "=>"},
+		{token.COLON, ":"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  try {\n\tthrow x\n}"
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.TRY, 2, 3},
+		{token.LBRACE, 2, 7},
+		{token.THROW, 3, 2},
+		{token.IDENT, 3, 8},
+		{token.RBRACE, 4, 1},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// leading\nlet x = 10 / 2; // trailing\n// last"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestUserOperators(t *testing.T) {
+	input := "a <> b < c +++ d ++ e ->> f"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{"<>", "<>"},
+		{token.IDENT, "b"},
+		{token.LT, "<"},
+		{token.IDENT, "c"},
+		{"+++", "+++"},
+		{token.IDENT, "d"},
+		{"++", "++"},
+		{token.IDENT, "e"},
+		{token.ARROW, "->"},
+		{token.GT, ">"},
+		{token.IDENT, "f"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	l.AddOperator("<>")
+	l.AddOperator("++")
+	l.AddOperator("+++")
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestIndentation(t *testing.T) {
+	input := `def f(a):
+    if a:
+
+        // a comment
+        return [1,
+  2]
+    return None
+f(1)
+`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "def"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.RPAREN, ")"},
+		{token.COLON, ":"},
+		{token.NEWLINE, ""},
+		{token.INDENT, ""},
+		{token.IF, "if"},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.NEWLINE, ""},
+		{token.INDENT, ""},
+		{token.RETURN, "return"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.NEWLINE, ""},
+		{token.DEDENT, ""},
+		{token.RETURN, "return"},
+		{token.IDENT, "None"},
+		{token.NEWLINE, ""},
+		{token.DEDENT, ""},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.INT, "1"},
+		{token.RPAREN, ")"},
+		{token.NEWLINE, ""},
+		{token.EOF, ""},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	l.UseIndentation()
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	l = New("if a:\n    b\n  c")
+	l.UseIndentation()
+	types := []token.TokenType{}
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		types = append(types, tok.Type)
+	}
+	expected := []token.TokenType{token.IF, token.IDENT, token.COLON, token.NEWLINE, token.INDENT,
+		token.IDENT, token.NEWLINE, token.DEDENT, token.ILLEGAL, token.IDENT, token.NEWLINE, token.DEDENT}
+	if len(types) != len(expected) {
+		t.Fatalf("expected=%v, got=%v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatalf("expected=%v, got=%v", expected, types)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	input := `"count: ${n + 1}!" "${h["k"]}${ {"a": 1} } and ${"in ${x}"}" "$ {}" "\${x} costs \$5 ${y}\$"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.TEMPLATE_HEAD, "count: "},
+		{token.IDENT, "n"},
+		{token.PLUS, "+"},
+		{token.INT, "1"},
+		{token.TEMPLATE_TAIL, "!"},
+		{token.TEMPLATE_HEAD, ""},
+		{token.IDENT, "h"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.TEMPLATE_MIDDLE, ""},
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.TEMPLATE_MIDDLE, " and "},
+		{token.TEMPLATE_HEAD, "in "},
+		{token.IDENT, "x"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.TEMPLATE_TAIL, ""},
+		{token.STRING, "$ {}"},
+		{token.TEMPLATE_HEAD, "${x} costs $5 "},
+		{token.IDENT, "y"},
+		{token.TEMPLATE_TAIL, "$"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
@@ -2,12 +2,27 @@ package main
 
 import (
 	"fmt"
+	"lang/evaluator"
 	"lang/repl"
 	"os"
 	"os/user"
 )
 
 func main() {
+	if dir := os.Getenv(evaluator.PreludeEnv); dir != "" {
+		if err := evaluator.LoadPrelude(os.DirFS(dir)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if len(os.Args) > 1 {
+		if !repl.RunFile(os.Args[1], os.Stderr) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
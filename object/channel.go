@@ -0,0 +1,106 @@
+package object
+
+import (
+	"errors"
+	"sync"
+)
+
+// Sharing values between tasks
+//
+// A spawned function runs on its own goroutine, and everything it closes over
+// or is passed is shared w/ the task that spawned it. Environments lock
+// internally, so reading and rebinding variables from several tasks is safe.
+// The values themselves fall in four groups:
+//
+//   - Integer, Boolean, Null, String, Symbol, Array, Hash, Function, Builtin,
+//     Variant and EnumValue never change once built, so they can be shared
+//     freely. A function literal bound by `let` is named as it's created,
+//     not renamed later
+//   - StructType, EnumType and Instance change in place, the first two when
+//     an impl block adds methods and Instance when a field is assigned. They
+//     lock internally, so tasks can read and assign them at the same time,
+//     though a read-modify-write like `p.x = p.x + 1` isn't atomic
+//   - Channel and Task exist to be shared; every operation on them is safe
+//     from any number of tasks
+//   - Generator isn't synchronized: resuming one advances it. Hand it from
+//     one task to another over a channel rather than using it from two tasks
+//     at once
+
+var ErrClosedChannel = errors.New("send on closed channel")
+
+// Channel is a Go channel of values. A size of 0 makes it unbuffered
+type Channel struct {
+	C chan Object
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewChannel(size int) *Channel {
+	return &Channel{C: make(chan Object, size)}
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string  { return "<channel>" }
+
+// Send blocks until val is received or buffered. Sending on a closed channel,
+// or one that gets closed while the send is blocked, fails w/ ErrClosedChannel
+func (c *Channel) Send(val Object) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = ErrClosedChannel
+		}
+	}()
+	c.C <- val
+	return nil
+}
+
+// Recv blocks until a value is available; ok is false once the channel is
+// closed and drained
+func (c *Channel) Recv() (val Object, ok bool) {
+	val, ok = <-c.C
+	return val, ok
+}
+
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("close of closed channel")
+	}
+	c.closed = true
+	close(c.C)
+	return nil
+}
+
+// Task is the handle to a spawned function call
+type Task struct {
+	Function string
+	done     chan struct{}
+	result   Object
+}
+
+func NewTask(name string) *Task {
+	return &Task{Function: name, done: make(chan struct{})}
+}
+
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+func (t *Task) Inspect() string {
+	if t.Function == "" {
+		return "<task>"
+	}
+	return "<task " + t.Function + ">"
+}
+
+// Finish records the task's result and wakes everyone waiting on it. It must
+// be called exactly once
+func (t *Task) Finish(result Object) {
+	t.result = result
+	close(t.done)
+}
+
+// Wait blocks until the task has finished and returns its result
+func (t *Task) Wait() Object {
+	<-t.done
+	return t.result
+}
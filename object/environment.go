@@ -1,9 +1,37 @@
 package object
 
+import "sync"
+
+// Environments can be shared by tasks running concurrently (a spawned closure
+// still sees the variables of the code that spawned it), so every access to
+// the store goes through mu
 type Environment struct {
+	mu    sync.RWMutex
 	store map[string]Object
+	// Names in store bound by `const`
+	consts map[string]bool
 	// The outer is needed for closures
 	outer *Environment
+	// Set on the environment a function call runs in
+	frame *Frame
+	// Set on the top level environment of an imported module
+	module *Module
+	// Set by SetOptions, usually on a top level environment
+	options *Options
+}
+
+// Options are evaluator settings for the code running in an environment and
+// every environment nested in it, including those of calls to functions
+// defined there and of the modules it imports. The zero value is the default
+type Options struct {
+	// Run if/try blocks in the enclosing environment, so a `let` inside one
+	// is still visible after it. That's how blocks used to behave; it's only
+	// here for code that still depends on it
+	SharedBlockScope bool
+	// How deep calls can nest before raising a StackOverflow error, 0 for
+	// the evaluator's default. Tail calls don't count towards it. Set it
+	// too high and deep recursion crashes the Go runtime instead
+	MaxCallDepth int
 }
 
 func NewEnvironment() *Environment {
@@ -12,18 +40,124 @@ func NewEnvironment() *Environment {
 }
 
 func (env *Environment) Get(name string) (Object, bool) {
+	env.mu.RLock()
 	obj, ok := env.store[name]
+	env.mu.RUnlock()
 	if !ok && env.outer != nil {
 		obj, ok = env.outer.Get(name)
 	}
 	return obj, ok
 }
+// Set binds name in env itself. A constant stays one: declarations go
+// through Declare, which won't rebind it, and Set is for the bindings the
+// evaluator makes itself, like a call's parameters
 func (env *Environment) Set(name string, val Object) Object {
+	env.mu.Lock()
+	defer env.mu.Unlock()
 	env.store[name] = val
 	return val
 }
+
+// Declare binds name in env itself, marking it as not assignable if constant
+// is set. It leaves the binding alone and reports false if env already binds
+// name as a constant
+func (env *Environment) Declare(name string, val Object, constant bool) (Object, bool) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.consts[name] {
+		return nil, false
+	}
+	env.store[name] = val
+	if constant {
+		if env.consts == nil {
+			env.consts = make(map[string]bool)
+		}
+		env.consts[name] = true
+	}
+	return val, true
+}
+
+// Assign rebinds name in the innermost scope that already defines it. It
+// reports whether there is one, and whether it binds name as a constant, in
+// which case the binding is left alone
+func (env *Environment) Assign(name string, val Object) (found, constant bool) {
+	env.mu.Lock()
+	if _, ok := env.store[name]; ok {
+		constant = env.consts[name]
+		if !constant {
+			env.store[name] = val
+		}
+		env.mu.Unlock()
+		return true, constant
+	}
+	env.mu.Unlock()
+	if env.outer != nil {
+		return env.outer.Assign(name, val)
+	}
+	return false, false
+}
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
 	return env
 }
+
+// NewCallEnvironment creates the environment a function body runs in
+func NewCallEnvironment(outer *Environment, frame *Frame) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.frame = frame
+	return env
+}
+
+// Frame returns the call frame code running in env belongs to, or nil at the
+// top level
+func (env *Environment) Frame() *Frame {
+	for e := env; e != nil; e = e.outer {
+		if e.frame != nil {
+			return e.frame
+		}
+	}
+	return nil
+}
+
+// SetOptions sets the options for env and the environments nested in it,
+// replacing those it would otherwise get from its outer environment. Set
+// them before evaluating anything in env: code that's already running may or
+// may not see the change
+func (env *Environment) SetOptions(opts Options) {
+	env.mu.Lock()
+	env.options = &opts
+	env.mu.Unlock()
+}
+
+// Options returns the options set on env or the nearest environment it's
+// nested in, the defaults if there aren't any
+func (env *Environment) Options() Options {
+	for e := env; e != nil; e = e.outer {
+		e.mu.RLock()
+		opts := e.options
+		e.mu.RUnlock()
+		if opts != nil {
+			return *opts
+		}
+	}
+	return Options{}
+}
+
+// NewModuleEnvironment creates the top level environment of a module
+func NewModuleEnvironment(module *Module) *Environment {
+	env := NewEnvironment()
+	env.module = module
+	return env
+}
+
+// Module returns the module code running in env belongs to, or nil outside
+// of any (the REPL, say)
+func (env *Environment) Module() *Module {
+	for e := env; e != nil; e = e.outer {
+		if e.module != nil {
+			return e.module
+		}
+	}
+	return nil
+}
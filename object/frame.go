@@ -0,0 +1,153 @@
+package object
+
+import "fmt"
+
+// Frame is one active function call. Frames are linked to their caller, so
+// the chain from the innermost frame is the call stack
+type Frame struct {
+	Function string // name of the called function, "" if anonymous
+	File     string // position of the call site
+	Line     int
+	Column   int
+	Parent   *Frame // nil for calls made from the top level
+	// The environment the call was made from, nil for calls a builtin makes
+	Env      *Environment
+	Depth    int // number of frames in the chain, this one included
+	Deferred []*Deferred
+	// Set on the frame of a generator call: hands a value to the consumer
+	// and blocks until it asks for the next one
+	Yield func(val Object)
+	// Set if the call was made in tail position and took over its caller's
+	// frame. The frame's position is then the caller's call site
+	Tail *TailCall
+}
+
+// TailCall stands in for the frame a tail call replaced, so traces still
+// show it: the caller's name, the position of the tail call in it, and how
+// many frames the tail calls before it had already replaced
+type TailCall struct {
+	Function string
+	File     string
+	Line     int
+	Column   int
+	Elided   int
+}
+
+// Deferred is a call queued by `defer`. The function and its arguments are
+// evaluated when the defer statement runs, the call itself when the frame's
+// function returns
+type Deferred struct {
+	Fn     Object
+	Args   []Object
+	File   string // position of the defer statement
+	Line   int
+	Column int
+}
+
+// NewFrame returns the frame for a call made at file:line:column from code
+// running in parent
+func NewFrame(parent *Frame, file string, line, column int) *Frame {
+	frame := &Frame{File: file, Line: line, Column: column, Parent: parent, Depth: 1}
+	if parent != nil {
+		frame.Depth = parent.Depth + 1
+	}
+	return frame
+}
+
+// NewTailFrame returns the frame for a tail call made at file:line:column
+// from code running in caller, which it replaces
+func NewTailFrame(caller *Frame, file string, line, column int) *Frame {
+	frame := NewFrame(caller.Parent, caller.File, caller.Line, caller.Column)
+	frame.Tail = &TailCall{Function: caller.Function, File: file, Line: line, Column: column}
+	if caller.Tail != nil {
+		frame.Tail.Elided = caller.Tail.Elided + 1
+	}
+	return frame
+}
+
+// CallSite is the position the frame's function was called from
+func (f *Frame) CallSite() (file string, line, column int) {
+	if f.Tail != nil {
+		return f.Tail.File, f.Tail.Line, f.Tail.Column
+	}
+	return f.File, f.Line, f.Column
+}
+
+// Caller rebuilds the frame a tail call replaced, e.g. to raise an error
+// from the call itself in it. The frames replaced before it are gone
+func (f *Frame) Caller() *Frame {
+	if f.Tail == nil {
+		return f.Parent
+	}
+	return &Frame{Function: f.Tail.Function, File: f.File, Line: f.Line, Column: f.Column, Parent: f.Parent, Depth: f.Depth}
+}
+
+func (f *Frame) name() string {
+	if f == nil {
+		return "<main>"
+	}
+	return functionName(f.Function)
+}
+
+func functionName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
+
+// TraceEnds is how many lines Trace keeps from each end of a long stack
+const TraceEnds = 10
+
+// Trace renders the stack for an error raised at file:line:column while
+// running frame, innermost call first, e.g. `at fib (math.mk:4:12)`. Each
+// line pairs a function w/ the position being executed inside it. A frame a
+// tail call replaced still gets its line, and the ones replaced before it a
+// count. Deep stacks (think runaway recursion) only keep their first and last
+// TraceEnds frames
+func Trace(frame *Frame, file string, line, column int) []string {
+	trace := []string{}
+	skipped, cut := 0, 0
+	for kept := 0; ; kept++ {
+		depth := 0
+		if frame != nil {
+			depth = frame.Depth
+		}
+		lines := []string{fmt.Sprintf("at %s (%s)", frame.name(), position(file, line, column))}
+		if frame != nil && frame.Tail != nil {
+			tc := frame.Tail
+			lines = append(lines, fmt.Sprintf("at %s (%s)", functionName(tc.Function), position(tc.File, tc.Line, tc.Column)))
+			switch {
+			case tc.Elided == 1:
+				lines = append(lines, "... 1 frame elided by tail calls")
+			case tc.Elided > 1:
+				lines = append(lines, fmt.Sprintf("... %d frames elided by tail calls", tc.Elided))
+			}
+		}
+		if kept < TraceEnds || depth < TraceEnds {
+			trace = append(trace, lines...)
+		} else {
+			if skipped == 0 {
+				cut = len(trace)
+			}
+			skipped++
+		}
+		if frame == nil {
+			break
+		}
+		file, line, column = frame.File, frame.Line, frame.Column
+		frame = frame.Parent
+	}
+	if skipped > 0 {
+		rest := append([]string{fmt.Sprintf("... %d more frames", skipped)}, trace[cut:]...)
+		trace = append(trace[:cut], rest...)
+	}
+	return trace
+}
+
+func position(file string, line, column int) string {
+	if file == "" {
+		file = "<input>"
+	}
+	return fmt.Sprintf("%s:%d:%d", file, line, column)
+}
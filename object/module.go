@@ -0,0 +1,22 @@
+package object
+
+// Module is what an import binds: a file evaluated once, in its own
+// environment, of which only the exported names can be reached
+type Module struct {
+	Name    string
+	Path    string // canonical path, which modules are cached by
+	Env     *Environment
+	Exports map[string]bool
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "<module " + m.Name + ">" }
+
+// Export looks up an exported binding. It reads the module's environment, so
+// importers see later changes to the binding
+func (m *Module) Export(name string) (Object, bool) {
+	if !m.Exports[name] {
+		return nil, false
+	}
+	return m.Env.Get(name)
+}
@@ -6,6 +6,7 @@ import (
 	"hash/fnv"
 	"lang/ast"
 	"strings"
+	"sync"
 )
 
 type ObjectType string
@@ -14,17 +15,41 @@ type Object interface {
 	Inspect() string
 }
 
+// TypeName is the name of obj's type as errors show it: the declared name for
+// values of user-defined types, e.g. "type mismatch: Point + INTEGER", the
+// ObjectType for everything else
+func TypeName(obj Object) string {
+	if named, ok := obj.(interface{ TypeName() string }); ok {
+		return named.TypeName()
+	}
+	return string(obj.Type())
+}
+
 const (
 	INTEGER_OBJ      = "INTEGER"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	ERROR_OBJ        = "ERROR"
+	EXCEPTION_OBJ    = "EXCEPTION"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	SYMBOL_OBJ       = "SYMBOL"
+	STRUCT_OBJ       = "STRUCT"
+	INSTANCE_OBJ     = "INSTANCE"
+	BOUND_METHOD_OBJ = "BOUND_METHOD"
+	ENUM_OBJ         = "ENUM"
+	VARIANT_OBJ      = "VARIANT"
+	ENUM_VALUE_OBJ   = "ENUM_VALUE"
+	GENERATOR_OBJ    = "GENERATOR"
+	CHANNEL_OBJ      = "CHANNEL"
+	TASK_OBJ         = "TASK"
+	MODULE_OBJ       = "MODULE"
+	QUOTE_OBJ        = "QUOTE"
+	MACRO_OBJ        = "MACRO"
 )
 
 type Integer struct {
@@ -53,14 +78,60 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Error kinds. These are stable so scripts can branch on `e.kind` in a catch
+// block
+const (
+	ERROR            = "Error" // thrown by user code w/o a more specific kind
+	TYPE_ERROR       = "TypeError"
+	OPERATOR_ERROR   = "OperatorError"
+	ARGUMENT_ERROR   = "ArgumentError"
+	NAME_ERROR       = "NameError"
+	MEMBER_ERROR     = "MemberError"
+	ASSIGNMENT_ERROR = "AssignmentError"
+	PATTERN_ERROR    = "PatternError"
+	MATCH_ERROR      = "MatchError"
+	UNWRAP_ERROR     = "UnwrapError"
+	ZERO_DIVISION    = "ZeroDivisionError"
+	INDEX_ERROR      = "IndexError"
+	INTERNAL_ERROR   = "InternalError" // a Go panic caught by the evaluator
+	STACK_OVERFLOW   = "StackOverflow"
+	IMPORT_ERROR     = "ImportError"
+	SYNTAX_ERROR     = "SyntaxError" // code handed to parse or eval doesn't parse
+)
+
+// Error is an error in flight: evaluation unwinds until it reaches a catch
+// block or the top of the program. Line and Column are 0 until the evaluator
+// attaches the position of the expression that raised it
 type Error struct {
+	Kind    string
 	Message string
+	Payload Object // optional value attached by `throw`, nil otherwise
+	File    string
+	Line    int
+	Column  int
+	Frame   *Frame // the call that was running when the error was raised
+}
+
+// Trace is the stack of calls that led to the error, innermost first
+func (e *Error) Trace() []string {
+	return Trace(e.Frame, e.File, e.Line, e.Column)
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return e.Message }
+func (e *Error) Inspect() string  { return e.Kind + ": " + e.Message }
+
+// Exception is a caught Error bound as an ordinary value, so it can be
+// inspected, stored and rethrown without unwinding the evaluator
+type Exception struct {
+	Err *Error
+}
+
+func (ex *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (ex *Exception) Inspect() string  { return ex.Err.Inspect() }
 
 type Function struct {
+	Name       string // declared or inferred from the binding, "" for anonymous functions
+	Generator  bool   // calling it returns a *Generator instead of running the body
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -69,18 +140,53 @@ type Function struct {
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
 	var out bytes.Buffer
+	out.WriteString(f.Signature())
+	out.WriteString(" {\n")
+	out.WriteString(f.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// Signature is the function's header, e.g. `fn add(a, b)`
+func (f *Function) Signature() string {
 	params := []string{}
 	for _, p := range f.Parameters {
 		params = append(params, p.String())
 	}
-	out.WriteString("fn")
-	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
-	out.WriteString("\n}")
+	name := ""
+	if f.Generator {
+		name = "*"
+	}
+	if f.Name != "" {
+		name += " " + f.Name
+	}
+	return "fn" + name + "(" + strings.Join(params, ", ") + ")"
+}
 
-	return out.String()
+// Quote is an unevaluated piece of code, as returned by `quote(...)`
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string  { return "QUOTE(" + q.Node.String() + ")" }
+
+// Macro is a macro definition. Macros only exist during expansion; they're
+// never bound in the environment a program runs in
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+	return "macro(" + strings.Join(params, ", ") + ") {\n" + m.Body.String() + "\n}"
 }
 
 type String struct {
@@ -93,6 +199,10 @@ func (so *String) Inspect() string  { return so.Value }
 type BuiltinFunction func(args ...Object) Object
 type Builtin struct {
 	Fn BuiltinFunction
+	// FrameFn replaces Fn for builtins that call back into user functions.
+	// It gets the frame of the builtin's own call so the callbacks land on
+	// the same stack
+	FrameFn func(frame *Frame, args ...Object) Object
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -170,3 +280,213 @@ func (h *Hash) Inspect() string {
 type Hashable interface {
 	HashKey() HashKey
 }
+
+// StructType is the value bound by a `struct` declaration. Calling it
+// constructs an Instance from positional arguments
+type StructType struct {
+	Name    string
+	Fields  []string
+	Methods MethodTable
+}
+
+// MethodTable holds the methods impl blocks add to a struct or enum. An impl
+// can run while other tasks are calling methods, so access is locked. The
+// zero value is an empty table
+type MethodTable struct {
+	mu      sync.RWMutex
+	methods map[string]*Function
+}
+
+func (mt *MethodTable) Get(name string) (*Function, bool) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	fn, ok := mt.methods[name]
+	return fn, ok
+}
+
+// Set adds or replaces the method called name
+func (mt *MethodTable) Set(name string, fn *Function) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if mt.methods == nil {
+		mt.methods = make(map[string]*Function)
+	}
+	mt.methods[name] = fn
+}
+
+func (st *StructType) Type() ObjectType { return STRUCT_OBJ }
+func (st *StructType) Inspect() string {
+	return "struct " + st.Name + " {" + strings.Join(st.Fields, ", ") + "}"
+}
+
+func (st *StructType) HasField(name string) bool {
+	for _, f := range st.Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Instance is a value of a user-defined struct type. Every instance has the
+// same ObjectType, whatever its struct is called, so a struct named e.g.
+// INTEGER can't pass for a builtin type; TypeName gives the struct's name.
+// Fields can be assigned while other tasks read them, so access is locked
+type Instance struct {
+	Struct *StructType
+
+	mu     sync.RWMutex
+	fields map[string]Object
+}
+
+// NewInstance builds an instance of st w/ the given field values, which it
+// takes ownership of
+func NewInstance(st *StructType, fields map[string]Object) *Instance {
+	return &Instance{Struct: st, fields: fields}
+}
+
+func (i *Instance) Get(name string) (Object, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	val, ok := i.fields[name]
+	return val, ok
+}
+
+// Set assigns the field called name, which must be one of the struct's
+func (i *Instance) Set(name string, val Object) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.fields[name] = val
+}
+
+func (i *Instance) Type() ObjectType { return INSTANCE_OBJ }
+func (i *Instance) TypeName() string { return i.Struct.Name }
+func (i *Instance) Inspect() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, name := range i.Struct.Fields {
+		val, _ := i.Get(name)
+		fields = append(fields, fmt.Sprintf("%s: %s", name, val.Inspect()))
+	}
+
+	out.WriteString(i.Struct.Name)
+	out.WriteString("{")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// BoundMethod is a method looked up through `recv.name`; applying it passes
+// Receiver as the first argument
+type BoundMethod struct {
+	Receiver Object
+	Name     string
+	Method   Object // *Function or *Builtin
+}
+
+func (bm *BoundMethod) Type() ObjectType { return BOUND_METHOD_OBJ }
+func (bm *BoundMethod) Inspect() string {
+	return fmt.Sprintf("method %s of %s", bm.Name, bm.Receiver.Inspect())
+}
+
+// Generator is a paused call to a generator function, or a lazy stage (map,
+// filter, ...) pulling from one. Next resumes it until it yields a value, or
+// reports ok=false once it's finished, w/ val set to the error that ended it
+// if it failed
+type Generator struct {
+	Name string
+	Next func() (val Object, ok bool)
+}
+
+func (g *Generator) Type() ObjectType { return GENERATOR_OBJ }
+func (g *Generator) Inspect() string {
+	if g.Name == "" {
+		return "<generator>"
+	}
+	return "<generator " + g.Name + ">"
+}
+
+// EnumType is the value bound by an `enum` declaration; its variants are
+// reached through member access, e.g. Shape.Circle
+type EnumType struct {
+	Name     string
+	Variants []*Variant
+	Methods  MethodTable
+}
+
+func (et *EnumType) Type() ObjectType { return ENUM_OBJ }
+func (et *EnumType) Inspect() string {
+	variants := []string{}
+	for _, v := range et.Variants {
+		variants = append(variants, v.signature())
+	}
+	return "enum " + et.Name + " {" + strings.Join(variants, ", ") + "}"
+}
+
+func (et *EnumType) Variant(name string) (*Variant, bool) {
+	for _, v := range et.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Variant describes one case of an enum. Variants w/ fields are constructors
+// and are applied like functions; unit variants have a single shared Unit
+// value instead
+type Variant struct {
+	Enum   *EnumType
+	Name   string
+	Fields []string
+	Unit   *EnumValue
+}
+
+func (v *Variant) Type() ObjectType { return VARIANT_OBJ }
+func (v *Variant) Inspect() string  { return "variant " + v.Enum.Name + "." + v.signature() }
+
+func (v *Variant) signature() string {
+	if len(v.Fields) == 0 {
+		return v.Name
+	}
+	return v.Name + "(" + strings.Join(v.Fields, ", ") + ")"
+}
+
+// EnumValue is a constructed variant. Like Instance, it has the same
+// ObjectType for every enum, and TypeName gives the enum's name
+type EnumValue struct {
+	Variant *Variant
+	Values  []Object
+}
+
+func (ev *EnumValue) Type() ObjectType { return ENUM_VALUE_OBJ }
+func (ev *EnumValue) TypeName() string { return ev.Variant.Enum.Name }
+func (ev *EnumValue) Inspect() string {
+	var out bytes.Buffer
+
+	out.WriteString(ev.Variant.Enum.Name + "." + ev.Variant.Name)
+	if len(ev.Variant.Fields) == 0 {
+		return out.String()
+	}
+
+	values := []string{}
+	for _, v := range ev.Values {
+		values = append(values, v.Inspect())
+	}
+	out.WriteString("(")
+	out.WriteString(strings.Join(values, ", "))
+	out.WriteString(")")
+	return out.String()
+}
+
+// AddVariant appends a variant to the enum, creating its shared Unit value if
+// it has no fields
+func (et *EnumType) AddVariant(name string, fields ...string) *Variant {
+	v := &Variant{Enum: et, Name: name, Fields: fields}
+	if len(fields) == 0 {
+		v.Unit = &EnumValue{Variant: v}
+	}
+	et.Variants = append(et.Variants, v)
+	return v
+}
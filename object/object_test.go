@@ -17,3 +17,40 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestSymbolInterning(t *testing.T) {
+	ok1 := Intern("ok")
+	ok2 := Intern("ok")
+	err := Intern("error")
+	if ok1 != ok2 {
+		t.Errorf("interning the same name returned different symbols")
+	}
+	if ok1.HashKey() != ok2.HashKey() {
+		t.Errorf("same symbol has different hash keys")
+	}
+	if ok1.HashKey() == err.HashKey() {
+		t.Errorf("different symbols have same hash keys")
+	}
+}
+
+func TestTraceTruncation(t *testing.T) {
+	var frame *Frame
+	for i := 1; i <= 50; i++ {
+		frame = NewFrame(frame, "deep.mk", i, 1)
+		frame.Function = "f"
+	}
+
+	trace := Trace(frame, "deep.mk", 99, 1)
+	if len(trace) != 2*TraceEnds+1 {
+		t.Fatalf("trace has wrong length. got=%d", len(trace))
+	}
+	if trace[0] != "at f (deep.mk:99:1)" {
+		t.Errorf("trace[0] wrong. got=%q", trace[0])
+	}
+	if trace[TraceEnds] != "... 31 more frames" {
+		t.Errorf("trace[%d] wrong. got=%q", TraceEnds, trace[TraceEnds])
+	}
+	if last := trace[len(trace)-1]; last != "at <main> (deep.mk:1:1)" {
+		t.Errorf("last line wrong. got=%q", last)
+	}
+}
@@ -0,0 +1,33 @@
+package object
+
+import "sync"
+
+// Symbols are interned: there is exactly one *Symbol per name, so two symbols
+// are equal iff they are the same pointer, and hashing is just reading the id
+// handed out when the name was first interned
+type Symbol struct {
+	Name string
+	id   uint64
+}
+
+func (s *Symbol) Type() ObjectType { return SYMBOL_OBJ }
+func (s *Symbol) Inspect() string  { return ":" + s.Name }
+func (s *Symbol) HashKey() HashKey { return HashKey{Type: s.Type(), Value: s.id} }
+
+var symbols = struct {
+	sync.Mutex
+	table map[string]*Symbol
+}{table: make(map[string]*Symbol)}
+
+// Intern returns the unique symbol for name, creating it on first use
+func Intern(name string) *Symbol {
+	symbols.Lock()
+	defer symbols.Unlock()
+
+	if sym, ok := symbols.table[name]; ok {
+		return sym
+	}
+	sym := &Symbol{Name: name, id: uint64(len(symbols.table))}
+	symbols.table[name] = sym
+	return sym
+}
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"fmt"
+	"lang/ast"
+	"lang/token"
+)
+
+// Extension adds syntax to a parser. Extend is called once for each parser
+// the extension is passed to w/ WithExtensions, before parsing starts, and
+// registers keywords and parse functions through the Register methods.
+// Nodes an extension produces that the ast package doesn't have embed
+// ast.Custom, and need an evaluator.RegisterNodeType to run
+type Extension interface {
+	Extend(p *Parser)
+}
+
+// ExtensionFunc lets an ordinary function be used as an Extension
+type ExtensionFunc func(p *Parser)
+
+func (f ExtensionFunc) Extend(p *Parser) { f(p) }
+
+// WithExtensions applies exts to the parser, in order
+func WithExtensions(exts ...Extension) Option {
+	return func(p *Parser) {
+		for _, ext := range exts {
+			ext.Extend(p)
+		}
+	}
+}
+
+// StatementParseFn parses a statement starting at the current token
+type StatementParseFn func() ast.Statement
+
+// RegisterKeyword makes word lex as a token of type tt instead of an
+// identifier
+func (p *Parser) RegisterKeyword(word string, tt token.TokenType) {
+	p.l.AddKeyword(word, tt)
+}
+
+// RegisterPrefix parses expressions starting w/ a tt token w/ fn. fn is
+// called w/ that token current, and must leave the current token on the last
+// token of the expression
+func (p *Parser) RegisterPrefix(tt token.TokenType, fn PrefixParseFn) {
+	p.registerPrefixFn(tt, fn)
+}
+
+// RegisterInfix parses a tt token following an expression w/ fn, passing it
+// the expression on the left. precedence is the token's binding power, one
+// of the constants from LOWEST to INDEX
+func (p *Parser) RegisterInfix(tt token.TokenType, precedence int, fn InfixParseFn) {
+	p.registerInfixFn(tt, fn)
+	p.precedences[tt] = precedence
+}
+
+// RegisterStatement parses statements starting w/ a tt token w/ fn, which
+// follows the same rules as a PrefixParseFn
+func (p *Parser) RegisterStatement(tt token.TokenType, fn StatementParseFn) {
+	p.statementParserFns[tt] = fn
+}
+
+// CurToken, PeekToken and NextToken give parse functions access to the
+// token stream
+func (p *Parser) CurToken() token.Token  { return p.curToken }
+func (p *Parser) PeekToken() token.Token { return p.peekToken }
+func (p *Parser) NextToken()             { p.nextToken() }
+
+// ExpectPeek advances if the next token is of type tt, and records an error
+// otherwise
+func (p *Parser) ExpectPeek(tt token.TokenType) bool {
+	return p.expectPeek(tt)
+}
+
+// ParseExpression parses the expression starting at the current token, w/
+// infix operators binding tighter than precedence
+func (p *Parser) ParseExpression(precedence int) ast.Expression {
+	return p.parseExpression(precedence)
+}
+
+// ParseBlockStatement parses a `{ ... }` block; the current token must be
+// the opening brace
+func (p *Parser) ParseBlockStatement() *ast.BlockStatement {
+	return p.parseBlockStatement()
+}
+
+// Errorf records a parse error
+func (p *Parser) Errorf(format string, a ...interface{}) {
+	p.errors = append(p.errors, fmt.Sprintf(format, a...))
+}
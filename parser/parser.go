@@ -81,13 +81,16 @@ import (
 	"lang/ast"
 	"lang/lexer"
 	"lang/token"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 // Operator Precedence
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
 	EQUALS
 	LESSGREATER
 	SUM
@@ -104,11 +107,26 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 
-	prefixParserFns map[token.TokenType]prefixParseFn
-	infixParserFns  map[token.TokenType]infixParseFn
+	prefixParserFns    map[token.TokenType]PrefixParseFn
+	infixParserFns     map[token.TokenType]InfixParseFn
+	statementParserFns map[token.TokenType]StatementParseFn // added by extensions
+	// Binding power of each infix token. Starts out as a copy of the
+	// builtin table; infix declarations add to it
+	precedences map[token.TokenType]int
+	rightAssoc  map[token.TokenType]bool
+	operators   []Operator
+	// Whether blocks can be written as `:` and an indented suite
+	indented bool
+
+	// The function literals enclosing the current token, innermost last
+	functions []*ast.FunctionLiteral
+	// The names declared in each enclosing block, innermost last, mapped to
+	// whether they're constants
+	scopes []map[string]bool
 }
 
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -118,7 +136,9 @@ var precedences = map[token.TokenType]int{
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
+	token.QUESTION: CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 /*
@@ -126,16 +146,16 @@ The argument for the infix function is the 'lhs' of the infix
 operator that's being parsed
 */
 type (
-	prefixParseFn func() ast.Expression
-	infixParseFn  func(ast.Expression) ast.Expression
+	PrefixParseFn func() ast.Expression
+	InfixParseFn  func(ast.Expression) ast.Expression
 )
 
 // Register fns for token types
-func (p *Parser) registerPrefixFn(tt token.TokenType, fn prefixParseFn) {
+func (p *Parser) registerPrefixFn(tt token.TokenType, fn PrefixParseFn) {
 	p.prefixParserFns[tt] = fn
 }
 
-func (p *Parser) registerInfixFn(tt token.TokenType, fn infixParseFn) {
+func (p *Parser) registerInfixFn(tt token.TokenType, fn InfixParseFn) {
 	p.infixParserFns[tt] = fn
 }
 
@@ -150,6 +170,9 @@ func (p *Parser) peekError(t token.TokenType) {
 }
 func (p *Parser) noPrefixParseError(tt token.TokenType) {
 	msg := fmt.Sprintf("Expected a valid prefix for %s", tt)
+	if tt == token.ILLEGAL {
+		msg += fmt.Sprintf(" (%s)", p.curToken.Literal)
+	}
 	p.errors = append(p.errors, msg)
 }
 
@@ -176,11 +199,40 @@ func (p *Parser) ParseProgram() *ast.Program {
 func (p *Parser) ParseStatement() ast.Statement {
 	// Parse statements by type
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.STRUCT:
+		return p.parseStructStatement()
+	case token.IMPL:
+		return p.parseImplStatement()
+	case token.ENUM:
+		return p.parseEnumStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.DEFER:
+		return p.parseDeferStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.INFIX:
+		return p.parseInfixStatement()
+	case token.DEF:
+		return p.parseFunctionStatement()
+	case token.NEWLINE:
+		// An empty statement; a line break on its own ends nothing
+		return nil
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) || p.peekTokenIs(token.ASTERISK) {
+			return p.parseFunctionStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
+		if fn, ok := p.statementParserFns[p.curToken.Type]; ok {
+			return fn()
+		}
 		return p.parseExpressionStatement()
 	}
 }
@@ -193,7 +245,16 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		return nil
 	}
 
-	leftExp := prefix()
+	return p.parseInfixExpressions(prefix(), precedence)
+}
+
+// parseInfixExpressions extends leftExp w/ any infix operators that bind
+// tighter than precedence
+func (p *Parser) parseInfixExpressions(leftExp ast.Expression, precedence int) ast.Expression {
+	if p.curTokenIs(token.DEDENT) {
+		// leftExp ended w/ an indented block, and so did its line
+		return leftExp
+	}
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParserFns[p.peekToken.Type]
 		if infix == nil {
@@ -237,6 +298,11 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.CurPrecedence()
+	if p.rightAssoc[p.curToken.Type] {
+		// binding a little looser lets the rhs take another instance of the
+		// same operator
+		precedence--
+	}
 	p.nextToken()
 	expr.Right = p.parseExpression(precedence)
 	return expr
@@ -257,13 +323,22 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := &ast.LetStatement{Token: p.curToken, Const: p.curTokenIs(token.CONST)}
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.declare(stmt.Name.Value, stmt.Const)
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		if stmt.Type = p.parseType(); stmt.Type == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -293,6 +368,15 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	return expr
 }
 
+// expectBlock advances to the opening of a block: a `{`, or in the
+// indentation syntax a `:` ending the line before an indented one
+func (p *Parser) expectBlock() bool {
+	if p.indented && !p.peekTokenIs(token.LBRACE) {
+		return p.expectPeek(token.COLON) && p.expectPeek(token.NEWLINE) && p.expectPeek(token.INDENT)
+	}
+	return p.expectPeek(token.LBRACE)
+}
+
 func (p *Parser) expectPeek(tt token.TokenType) bool {
 	// Expect peek is an 'assertion' function (very commmon in parsers)
 	// Meant to enforce correctness of token order
@@ -308,14 +392,14 @@ func (p *Parser) peekTokenIs(tt token.TokenType) bool {
 }
 
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
+	if p, ok := p.precedences[p.peekToken.Type]; ok {
 		return p
 	}
 	return LOWEST
 }
 
 func (p *Parser) CurPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
+	if p, ok := p.precedences[p.curToken.Type]; ok {
 		return p
 	}
 	return LOWEST
@@ -342,85 +426,293 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 func (p *Parser) parseIfStatement() ast.Expression {
 	expr := &ast.IfExpression{Token: p.curToken}
 
-	if !p.expectPeek(token.LPAREN) {
-		return nil
-	}
-	// now curToken is LPAREN
+	if p.indented {
+		// `if cond:`, no parentheses needed
+		p.nextToken()
+		expr.Condition = p.parseExpression(LOWEST)
+	} else {
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		// now curToken is LPAREN
 
-	p.nextToken()
+		p.nextToken()
 
-	// now curToken is first token in the condition
+		// now curToken is first token in the condition
 
-	expr.Condition = p.parseExpression(LOWEST)
+		expr.Condition = p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
 	}
-	// expectPeek also incrs the tokens
-	if !p.expectPeek(token.LBRACE) {
+	if !p.expectBlock() {
 		return nil
 	}
 
 	expr.Consequence = p.parseBlockStatement()
 
-	if p.peekTokenIs(token.ELSE) {
+	switch {
+	case p.peekTokenIs(token.ELSE):
 		// Consume the else token
 		p.nextToken()
-		if !p.expectPeek(token.LBRACE) {
+		if !p.expectBlock() {
 			return nil
 		}
 		expr.Alternative = p.parseBlockStatement()
+	case p.peekTokenIs(token.ELIF):
+		// `elif cond:` is an else block holding just another if
+		p.nextToken()
+		tok := p.curToken
+		elif := p.parseIfStatement()
+		if elif == nil {
+			return nil
+		}
+		expr.Alternative = &ast.BlockStatement{Token: tok, Statements: []ast.Statement{
+			&ast.ExpressionStatement{Token: tok, Expression: elif},
+		}}
 	}
 
 	return expr
 }
 
+func (p *Parser) parseFunctionStatement() ast.Statement {
+	stmt := &ast.FunctionStatement{Token: p.curToken}
+
+	fl, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+	if !ok {
+		return nil
+	}
+	if fl.Name == "" {
+		// An anonymous `fn*(...) {...}`, which is just an expression
+		expr := &ast.ExpressionStatement{Token: stmt.Token}
+		expr.Expression = p.parseInfixExpressions(fl, LOWEST)
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return expr
+	}
+	stmt.Function = fl
+	stmt.Name = &ast.Identifier{Token: stmt.Token, Value: fl.Name}
+	p.declare(fl.Name, false)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	fl := &ast.FunctionLiteral{Token: p.curToken}
 
+	if p.peekTokenIs(token.ASTERISK) {
+		p.nextToken()
+		fl.Generator = true
+	}
+
+	// Named function expressions are allowed too; the name only shows up in
+	// stack traces and Inspect
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		fl.Name = p.curToken.Literal
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	fl.Parameters = p.parseFunctionParameters()
-	if !p.expectPeek(token.LBRACE) {
+	if !p.parseFunctionSignature(fl) {
+		return nil
+	}
+	if !p.expectBlock() {
 		return nil
 	}
-	fl.Body = p.parseBlockStatement()
+	fl.Body = p.parseFunctionBody(fl)
 	return fl
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	params := []*ast.Identifier{}
+func (p *Parser) parseFunctionBody(fl *ast.FunctionLiteral) *ast.BlockStatement {
+	p.functions = append(p.functions, fl)
+	p.pushScope(fl.Parameters...)
+	defer func() {
+		p.functions = p.functions[:len(p.functions)-1]
+		p.popScope()
+	}()
+	body := p.parseBlockStatement()
+	ast.MarkTailCalls(body)
+	return body
+}
+
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	ml := &ast.MacroLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if ml.Parameters = p.parseIdentifierList(); ml.Parameters == nil {
+		return nil
+	}
+	if !p.expectBlock() {
+		return nil
+	}
+	p.pushScope(ml.Parameters...)
+	defer p.popScope()
+	ml.Body = p.parseBlockStatement()
+	return ml
+}
+
+// parseIdentifierList parses `(a, b, ...)`; curToken is the LPAREN
+func (p *Parser) parseIdentifierList() []*ast.Identifier {
+	idents := []*ast.Identifier{}
 
-	// At this point, curToken should be the LPAREN
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return params
+		return idents
 	}
 
 	p.nextToken()
-	param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	params = append(params, param)
+	idents = append(idents, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		params = append(params, param)
+		idents = append(idents, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
 	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
-	return params
+	return idents
+}
+
+// parseFunctionSignature parses the parameter list, each parameter
+// optionally annotated as `name: type`, and an optional `-> type` return
+// annotation into fl
+func (p *Parser) parseFunctionSignature(fl *ast.FunctionLiteral) bool {
+	fl.Parameters = []*ast.Identifier{}
+	fl.ParamTypes = []*ast.TypeExpr{}
+
+	// At this point, curToken should be the LPAREN
+	for !p.peekTokenIs(token.RPAREN) {
+		if len(fl.Parameters) > 0 && !p.expectPeek(token.COMMA) {
+			return false
+		}
+		p.nextToken()
+		param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		var paramType *ast.TypeExpr
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			if paramType = p.parseType(); paramType == nil {
+				return false
+			}
+		}
+		fl.Parameters = append(fl.Parameters, param)
+		fl.ParamTypes = append(fl.ParamTypes, paramType)
+	}
+	p.nextToken()
+
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		p.nextToken()
+		if fl.ReturnType = p.parseType(); fl.ReturnType == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseType parses a type annotation starting at the current token:
+// `name`, `name[T, ...]`, `fn(T, ...) -> R`, or several of those joined by |
+func (p *Parser) parseType() *ast.TypeExpr {
+	t := p.parseSingleType()
+	if t == nil || !p.peekTokenIs(token.PIPE) {
+		return t
+	}
+
+	union := &ast.TypeExpr{Token: t.Token, Name: "|", Params: []*ast.TypeExpr{t}}
+	for p.peekTokenIs(token.PIPE) {
+		p.nextToken()
+		p.nextToken()
+		member := p.parseSingleType()
+		if member == nil {
+			return nil
+		}
+		union.Params = append(union.Params, member)
+	}
+	return union
+}
+
+func (p *Parser) parseSingleType() *ast.TypeExpr {
+	t := &ast.TypeExpr{Token: p.curToken, Name: p.curToken.Literal}
+
+	switch p.curToken.Type {
+	case token.IDENT, token.NULL:
+	case token.FUNCTION:
+		t.Name = "fn"
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if t.Params = p.parseTypeList(token.RPAREN); t.Params == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.ARROW) {
+			p.nextToken()
+			p.nextToken()
+			if t.Return = p.parseType(); t.Return == nil {
+				return nil
+			}
+		}
+		return t
+	default:
+		msg := fmt.Sprintf("expected a type, got %s", p.curToken.Type)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	if p.peekTokenIs(token.LBRACKET) {
+		p.nextToken()
+		if t.Params = p.parseTypeList(token.RBRACKET); len(t.Params) == 0 {
+			return nil
+		}
+	}
+	return t
+}
+
+// parseTypeList parses comma separated types up to end; curToken is the
+// opening delimiter. It returns nil on error
+func (p *Parser) parseTypeList(end token.TokenType) []*ast.TypeExpr {
+	types := []*ast.TypeExpr{}
+	for !p.peekTokenIs(end) {
+		if len(types) > 0 && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+		p.nextToken()
+		t := p.parseType()
+		if t == nil {
+			return nil
+		}
+		types = append(types, t)
+	}
+	p.nextToken()
+	return types
 }
 
+// parseBlockStatement parses the block opening at the current token: a `{`,
+// or an INDENT, whose block ends at the matching DEDENT
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	stmt := &ast.BlockStatement{Token: p.curToken}
 	stmt.Statements = []ast.Statement{}
+	end := token.TokenType(token.RBRACE)
+	if p.curTokenIs(token.INDENT) {
+		end = token.DEDENT
+	}
 	p.nextToken()
 
-	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+	p.pushScope()
+	defer p.popScope()
+
+	for !p.curTokenIs(end) && !p.curTokenIs(token.EOF) {
 		if s := p.ParseStatement(); s != nil {
 			stmt.Statements = append(stmt.Statements, s)
 		}
@@ -464,6 +756,30 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
+
+// parseInterpolatedString parses `"text ${expr} text ..."`, which the lexer
+// splits into a TEMPLATE_HEAD, then each expression followed by a
+// TEMPLATE_MIDDLE or, after the last, a TEMPLATE_TAIL
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	is := &ast.InterpolatedString{Token: p.curToken}
+	for {
+		is.Parts = append(is.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		if p.curTokenIs(token.TEMPLATE_TAIL) {
+			return is
+		}
+		if p.peekTokenIs(token.TEMPLATE_MIDDLE) || p.peekTokenIs(token.TEMPLATE_TAIL) {
+			p.errors = append(p.errors, "empty interpolation in string")
+			return nil
+		}
+		p.nextToken()
+		is.Parts = append(is.Parts, p.parseExpression(LOWEST))
+		if p.peekTokenIs(token.TEMPLATE_MIDDLE) {
+			p.nextToken()
+		} else if !p.expectPeek(token.TEMPLATE_TAIL) {
+			return nil
+		}
+	}
+}
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
@@ -533,42 +849,796 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 	return hash
 }
-func New(lexer *lexer.Lexer) *Parser {
-	p := &Parser{l: lexer, errors: []string{}}
-	// Prefix fns
-	p.prefixParserFns = make(map[token.TokenType]prefixParseFn)
-	p.registerPrefixFn(token.IDENT, p.parseIdentifier)
-	p.registerPrefixFn(token.INT, p.parseIntegerLiteral)
-	p.registerPrefixFn(token.MINUS, p.parsePrefixExpression)
-	p.registerPrefixFn(token.BANG, p.parsePrefixExpression)
-	p.registerPrefixFn(token.LPAREN, p.parseGroupedExpression)
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
 
-	p.registerPrefixFn(token.TRUE, p.parseBoolean)
-	p.registerPrefixFn(token.FALSE, p.parseBoolean)
+func (p *Parser) parseSymbolLiteral() ast.Expression {
+	return &ast.SymbolLiteral{Token: p.curToken, Value: p.curToken.Literal}
+}
 
-	p.registerPrefixFn(token.IF, p.parseIfStatement)
-	p.registerPrefixFn(token.FUNCTION, p.parseFunctionLiteral)
-	p.registerPrefixFn(token.STRING, p.parseStringLiteral)
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expr := &ast.MatchExpression{Token: p.curToken}
 
-	p.registerPrefixFn(token.LBRACKET, p.parseArrayLiteral)
-	p.registerPrefixFn(token.LBRACE, p.parseHashLiteral)
-	// Infix fns
-	p.infixParserFns = make(map[token.TokenType]infixParseFn)
-	p.registerInfixFn(token.PLUS, p.parseInfixExpression)
-	p.registerInfixFn(token.MINUS, p.parseInfixExpression)
-	p.registerInfixFn(token.SLASH, p.parseInfixExpression)
-	p.registerInfixFn(token.ASTERISK, p.parseInfixExpression)
-	p.registerInfixFn(token.EQ, p.parseInfixExpression)
-	p.registerInfixFn(token.NOT_EQ, p.parseInfixExpression)
-	p.registerInfixFn(token.LT, p.parseInfixExpression)
-	p.registerInfixFn(token.GT, p.parseInfixExpression)
-	p.registerInfixFn(token.LPAREN, p.parseCallExpression)
-	p.registerInfixFn(token.STRING, p.parseInfixExpression)
-	p.registerInfixFn(token.LBRACKET, p.parseIndexExpression)
-	// Sets curToken to peekToken (which is nil at this point), sets peekToken = 0
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
 	p.nextToken()
-	// Sets curToken to peekToken (which is now 0), sets peekToken = 1
+	expr.Subject = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := &ast.MatchArm{Pattern: p.parseExpression(LOWEST)}
+
+		if !p.expectPeek(token.FAT_ARROW) {
+			return nil
+		}
+		p.nextToken()
+
+		// Whatever the pattern binds shadows outer names in the arm body
+		p.pushScope(patternIdentifiers(arm.Pattern)...)
+		arm.Body = p.parseArmBody()
+		p.popScope()
+		expr.Arms = append(expr.Arms, arm)
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return expr
+}
+
+// parseArmBody parses what follows the => of a match or select arm: either a
+// block or a single expression, which gets wrapped in a block so the
+// evaluator only deals w/ one shape
+func (p *Parser) parseArmBody() *ast.BlockStatement {
+	if p.curTokenIs(token.LBRACE) {
+		return p.parseBlockStatement()
+	}
+	body := &ast.BlockStatement{Token: p.curToken}
+	body.Statements = []ast.Statement{&ast.ExpressionStatement{
+		Token:      p.curToken,
+		Expression: p.parseExpression(LOWEST),
+	}}
+	return body
+}
+
+func (p *Parser) parseSpawnExpression() ast.Expression {
+	expr := &ast.SpawnExpression{Token: p.curToken}
+
 	p.nextToken()
+	call, ok := p.parseExpression(PREFIX).(*ast.CallExpression)
+	if !ok {
+		p.errors = append(p.errors, "spawn requires a function call")
+		return nil
+	}
+	expr.Call = call
 
-	return p
+	return expr
+}
+
+func (p *Parser) parseAwaitExpression() ast.Expression {
+	expr := &ast.AwaitExpression{Token: p.curToken}
+
+	p.nextToken()
+	expr.Value = p.parseExpression(PREFIX)
+
+	return expr
+}
+
+func (p *Parser) parseSelectExpression() ast.Expression {
+	expr := &ast.SelectExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		head := p.parseExpression(LOWEST)
+
+		if !p.expectPeek(token.FAT_ARROW) {
+			return nil
+		}
+		p.nextToken()
+
+		if ident, ok := head.(*ast.Identifier); ok && ident.Value == "_" {
+			expr.Default = p.parseArmBody()
+		} else {
+			sc := p.selectCase(head)
+			if sc == nil {
+				return nil
+			}
+			if sc.Binding != nil {
+				p.pushScope(sc.Binding)
+			} else {
+				p.pushScope()
+			}
+			sc.Body = p.parseArmBody()
+			p.popScope()
+			expr.Cases = append(expr.Cases, sc)
+		}
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	if len(expr.Cases) == 0 {
+		p.errors = append(p.errors, "select needs at least one recv or send case")
+		return nil
+	}
+
+	return expr
+}
+
+// selectCase checks the head of a select arm has one of the shapes
+// `recv(ch)`, `name = recv(ch)` or `send(ch, value)`
+func (p *Parser) selectCase(head ast.Expression) *ast.SelectCase {
+	sc := &ast.SelectCase{}
+	if assign, ok := head.(*ast.AssignExpression); ok {
+		if ident, ok := assign.Target.(*ast.Identifier); ok {
+			sc.Binding = ident
+			head = assign.Value
+		}
+	}
+
+	call, ok := head.(*ast.CallExpression)
+	if ok {
+		op, _ := call.Function.(*ast.Identifier)
+		switch {
+		case op == nil:
+		case op.Value == "recv" && len(call.Arguments) == 1:
+			sc.Token = op.Token
+			sc.Channel = call.Arguments[0]
+			return sc
+		case op.Value == "send" && len(call.Arguments) == 2 && sc.Binding == nil:
+			sc.Token = op.Token
+			sc.Channel, sc.Value = call.Arguments[0], call.Arguments[1]
+			return sc
+		}
+	}
+
+	if head != nil {
+		msg := fmt.Sprintf("invalid select case: %s", head.String())
+		p.errors = append(p.errors, msg)
+	}
+	return nil
+}
+
+func (p *Parser) parseStructStatement() ast.Statement {
+	stmt := &ast.StructStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		field := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		stmt.Fields = append(stmt.Fields, field)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseEnumStatement() ast.Statement {
+	stmt := &ast.EnumStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		variant := &ast.EnumVariant{
+			Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		}
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			variant.Fields = p.parseIdentifierList()
+		}
+		stmt.Variants = append(stmt.Variants, variant)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseImplStatement() ast.Statement {
+	stmt := &ast.ImplStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+			continue
+		}
+		if !p.expectPeek(token.FUNCTION) {
+			return nil
+		}
+		fl := &ast.FunctionLiteral{Token: p.curToken}
+
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		method := &ast.MethodDefinition{
+			Name:     &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+			Function: fl,
+		}
+		fl.Name = p.curToken.Literal
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.parseFunctionSignature(fl) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		fl.Body = p.parseFunctionBody(fl)
+
+		stmt.Methods = append(stmt.Methods, method)
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// atTopLevel reports whether the current token is outside any block
+func (p *Parser) atTopLevel() bool {
+	return len(p.scopes) == 1
+}
+
+func (p *Parser) parseImportStatement() ast.Statement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if !p.atTopLevel() {
+		p.errors = append(p.errors, "import must be at the top level")
+		return nil
+	}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = p.curToken.Literal
+
+	if p.peekTokenIs(token.AS) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		p.declare(stmt.Alias.Value, false)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// Operator is a user-defined infix operator
+type Operator struct {
+	Symbol     string
+	Precedence int
+	RightAssoc bool
+}
+
+// precedenceNames are the precedences an infix declaration can ask for
+var precedenceNames = map[string]int{
+	"EQUALS":      EQUALS,
+	"LESSGREATER": LESSGREATER,
+	"SUM":         SUM,
+	"PRODUCT":     PRODUCT,
+}
+
+// operatorChars are the characters user-defined operators are made of.
+// Operators the language already has can't be redefined
+const operatorChars = "+-*/<>=!|&^%~?@$"
+
+var builtinOperators = map[string]bool{
+	"=": true, "==": true, "!=": true, "=>": true, "->": true, "+": true, "-": true,
+	"*": true, "/": true, "<": true, ">": true, "!": true, "?": true, "|": true,
+}
+
+// DeclareOperator adds op to the parser's tables and teaches its lexer to
+// read it as one token. It only affects tokens the parser hasn't looked at
+// yet, so declaring operators for a whole input goes through WithOperators
+func (p *Parser) DeclareOperator(op Operator) {
+	tt := token.TokenType(op.Symbol)
+	p.l.AddOperator(op.Symbol)
+	p.precedences[tt] = op.Precedence
+	p.rightAssoc[tt] = op.RightAssoc
+	p.registerInfixFn(tt, p.parseInfixExpression)
+	p.operators = append(p.operators, op)
+}
+
+// Operators are the operators declared so far, in order
+func (p *Parser) Operators() []Operator {
+	return p.operators
+}
+
+func (p *Parser) parseInfixStatement() ast.Statement {
+	stmt := &ast.InfixStatement{Token: p.curToken, Assoc: "left"}
+
+	if !p.atTopLevel() {
+		p.errors = append(p.errors, "infix declarations must be at the top level")
+		return nil
+	}
+
+	// The lexer doesn't know the operator yet, so it comes in as several
+	// tokens w/ nothing between them
+	p.nextToken()
+	stmt.Operator = p.curToken.Literal
+	for !p.peekTokenIs(token.LPAREN) && p.peekToken.Line == p.curToken.Line &&
+		p.peekToken.Column == p.curToken.Column+len(p.curToken.Literal) {
+		p.nextToken()
+		stmt.Operator += p.curToken.Literal
+	}
+	if strings.Trim(stmt.Operator, operatorChars) != "" || builtinOperators[stmt.Operator] ||
+		strings.HasPrefix(stmt.Operator, "//") {
+		p.errors = append(p.errors, fmt.Sprintf("cannot declare operator %s", stmt.Operator))
+		return nil
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	for !p.peekTokenIs(token.RPAREN) {
+		if stmt.Precedence != "" && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		key := p.curToken.Literal
+		if !p.expectPeek(token.COLON) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		switch value := p.curToken.Literal; {
+		case key == "precedence" && precedenceNames[value] != 0:
+			stmt.Precedence = value
+		case key == "assoc" && (value == "left" || value == "right"):
+			stmt.Assoc = value
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("invalid operator option %s: %s", key, value))
+			return nil
+		}
+	}
+	p.nextToken()
+	if stmt.Precedence == "" {
+		p.errors = append(p.errors, fmt.Sprintf("operator %s needs a precedence", stmt.Operator))
+		return nil
+	}
+
+	// Declared before the function is parsed so it can use the operator
+	p.DeclareOperator(Operator{
+		Symbol:     stmt.Operator,
+		Precedence: precedenceNames[stmt.Precedence],
+		RightAssoc: stmt.Assoc == "right",
+	})
+
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Function = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseExportStatement() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
+
+	if !p.atTopLevel() {
+		p.errors = append(p.errors, "export must be at the top level")
+		return nil
+	}
+	p.nextToken()
+	stmt.Statement = p.ParseStatement()
+	if stmt.Statement == nil {
+		return nil
+	}
+	if stmt.ExportedName() == "" {
+		msg := fmt.Sprintf("cannot export %s", stmt.Statement.String())
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseDeferStatement() ast.Statement {
+	stmt := &ast.DeferStatement{Token: p.curToken}
+
+	if len(p.functions) == 0 {
+		p.errors = append(p.errors, "defer outside function")
+		return nil
+	}
+
+	p.nextToken()
+	call, ok := p.parseExpression(LOWEST).(*ast.CallExpression)
+	if !ok {
+		p.errors = append(p.errors, "defer requires a function call")
+		return nil
+	}
+	stmt.Call = call
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseYieldExpression() ast.Expression {
+	expr := &ast.YieldExpression{Token: p.curToken}
+
+	if len(p.functions) == 0 {
+		p.errors = append(p.errors, "yield outside function")
+		return nil
+	}
+	// Any function that yields is a generator, fn* or not
+	p.functions[len(p.functions)-1].Generator = true
+
+	p.nextToken()
+	expr.Value = p.parseExpression(LOWEST)
+	return expr
+}
+
+func (p *Parser) parseForExpression() ast.Expression {
+	expr := &ast.ForExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expr.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	expr.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectBlock() {
+		return nil
+	}
+
+	p.pushScope(expr.Variable)
+	expr.Body = p.parseBlockStatement()
+	p.popScope()
+
+	return expr
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expr := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectBlock() {
+		return nil
+	}
+	expr.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			expr.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectBlock() {
+			return nil
+		}
+		if expr.CatchParam != nil {
+			p.pushScope(expr.CatchParam)
+		} else {
+			p.pushScope()
+		}
+		expr.Catch = p.parseBlockStatement()
+		p.popScope()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectBlock() {
+			return nil
+		}
+		expr.Finally = p.parseBlockStatement()
+	}
+
+	if expr.Catch == nil && expr.Finally == nil {
+		p.errors = append(p.errors, "Expected catch or finally after try block")
+		return nil
+	}
+
+	return expr
+}
+
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	expr := &ast.MemberExpression{Token: p.curToken, Object: object}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expr.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	return expr
+}
+
+func (p *Parser) parsePropagateExpression(left ast.Expression) ast.Expression {
+	// Postfix: there's no right hand side to parse
+	return &ast.PropagateExpression{Token: p.curToken, Left: left}
+}
+
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expr := &ast.AssignExpression{Token: p.curToken, Target: target}
+
+	switch target := target.(type) {
+	case *ast.Identifier:
+		if p.isConst(target.Value) {
+			msg := fmt.Sprintf("cannot assign to constant %s", target.Value)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+	case *ast.MemberExpression:
+	default:
+		msg := fmt.Sprintf("Cannot assign to %s", target.String())
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	// Assignment is right associative: a = b = c is a = (b = c)
+	p.nextToken()
+	expr.Value = p.parseExpression(ASSIGN - 1)
+
+	return expr
+}
+
+// Option configures a parser; see New
+type Option func(*Parser)
+
+// WithOperators declares ops before parsing starts. The REPL uses it to carry
+// operators over from earlier lines
+func WithOperators(ops ...Operator) Option {
+	return func(p *Parser) {
+		for _, op := range ops {
+			p.DeclareOperator(op)
+		}
+	}
+}
+
+// WithIndentation switches to the indentation syntax: blocks are written as
+// a `:` and an indented suite, as in `def f(x):` or `if x > 1:`, a line
+// break ends a statement, and `def` and `elif` are keywords. Braces keep
+// working for blocks on a single line. The tree is the same as for the
+// equivalent brace syntax
+func WithIndentation() Option {
+	return func(p *Parser) {
+		p.indented = true
+		p.l.UseIndentation()
+		p.l.AddKeyword("def", token.DEF)
+		p.l.AddKeyword("elif", token.ELIF)
+	}
+}
+
+// IndentedExt is the file extension of scripts in the indentation syntax
+const IndentedExt = ".mki"
+
+// NewFile returns a parser for src, read from the file at path, in the
+// syntax the file's extension selects
+func NewFile(path, src string, options ...Option) *Parser {
+	if filepath.Ext(path) == IndentedExt {
+		options = append([]Option{WithIndentation()}, options...)
+	}
+	return New(lexer.NewFile(path, src), options...)
+}
+
+func New(lexer *lexer.Lexer, options ...Option) *Parser {
+	p := &Parser{l: lexer, errors: []string{}}
+	p.pushScope() // the top level
+	// Prefix fns
+	p.prefixParserFns = make(map[token.TokenType]PrefixParseFn)
+	p.registerPrefixFn(token.IDENT, p.parseIdentifier)
+	p.registerPrefixFn(token.INT, p.parseIntegerLiteral)
+	p.registerPrefixFn(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefixFn(token.BANG, p.parsePrefixExpression)
+	p.registerPrefixFn(token.LPAREN, p.parseGroupedExpression)
+
+	p.registerPrefixFn(token.TRUE, p.parseBoolean)
+	p.registerPrefixFn(token.FALSE, p.parseBoolean)
+
+	p.registerPrefixFn(token.IF, p.parseIfStatement)
+	p.registerPrefixFn(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefixFn(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefixFn(token.YIELD, p.parseYieldExpression)
+	p.registerPrefixFn(token.FOR, p.parseForExpression)
+	p.registerPrefixFn(token.SPAWN, p.parseSpawnExpression)
+	p.registerPrefixFn(token.AWAIT, p.parseAwaitExpression)
+	p.registerPrefixFn(token.SELECT, p.parseSelectExpression)
+	p.registerPrefixFn(token.STRING, p.parseStringLiteral)
+	p.registerPrefixFn(token.TEMPLATE_HEAD, p.parseInterpolatedString)
+
+	p.registerPrefixFn(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefixFn(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefixFn(token.NULL, p.parseNullLiteral)
+	p.registerPrefixFn(token.SYMBOL, p.parseSymbolLiteral)
+	p.registerPrefixFn(token.MATCH, p.parseMatchExpression)
+	p.registerPrefixFn(token.TRY, p.parseTryExpression)
+	// Infix fns
+	p.infixParserFns = make(map[token.TokenType]InfixParseFn)
+	p.registerInfixFn(token.PLUS, p.parseInfixExpression)
+	p.registerInfixFn(token.MINUS, p.parseInfixExpression)
+	p.registerInfixFn(token.SLASH, p.parseInfixExpression)
+	p.registerInfixFn(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfixFn(token.EQ, p.parseInfixExpression)
+	p.registerInfixFn(token.NOT_EQ, p.parseInfixExpression)
+	p.registerInfixFn(token.LT, p.parseInfixExpression)
+	p.registerInfixFn(token.GT, p.parseInfixExpression)
+	p.registerInfixFn(token.LPAREN, p.parseCallExpression)
+	p.registerInfixFn(token.STRING, p.parseInfixExpression)
+	p.registerInfixFn(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfixFn(token.DOT, p.parseMemberExpression)
+	p.registerInfixFn(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfixFn(token.QUESTION, p.parsePropagateExpression)
+	p.precedences = make(map[token.TokenType]int, len(precedences))
+	for tt, precedence := range precedences {
+		p.precedences[tt] = precedence
+	}
+	p.rightAssoc = make(map[token.TokenType]bool)
+	p.statementParserFns = make(map[token.TokenType]StatementParseFn)
+	for _, option := range options {
+		option(p)
+	}
+	// Sets curToken to peekToken (which is nil at this point), sets peekToken = 0
+	p.nextToken()
+	// Sets curToken to peekToken (which is now 0), sets peekToken = 1
+	p.nextToken()
+
+	return p
+}
+
+func (p *Parser) pushScope(names ...*ast.Identifier) {
+	scope := make(map[string]bool, len(names))
+	for _, name := range names {
+		scope[name.Value] = false
+	}
+	p.scopes = append(p.scopes, scope)
+}
+
+func (p *Parser) popScope() {
+	p.scopes = p.scopes[:len(p.scopes)-1]
+}
+
+// declare records name in the innermost scope. Declaring a name again in the
+// scope that declared it as a constant is an error
+func (p *Parser) declare(name string, isConst bool) {
+	scope := p.scopes[len(p.scopes)-1]
+	if scope[name] {
+		p.errors = append(p.errors, fmt.Sprintf("cannot redeclare constant %s", name))
+		return
+	}
+	scope[name] = isConst
+}
+
+// isConst reports whether name resolves to a constant as far as the parser
+// can tell. Names declared elsewhere (an earlier REPL line, a builtin) aren't
+// known here; those are only caught at runtime
+func (p *Parser) isConst(name string) bool {
+	for ix := len(p.scopes) - 1; ix >= 0; ix-- {
+		if isConst, ok := p.scopes[ix][name]; ok {
+			return isConst
+		}
+	}
+	return false
+}
+
+// patternIdentifiers collects the identifiers a match pattern might bind
+func patternIdentifiers(pattern ast.Expression) []*ast.Identifier {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		return []*ast.Identifier{pattern}
+	case *ast.CallExpression:
+		return patternListIdentifiers(pattern.Arguments)
+	case *ast.ArrayLiteral:
+		return patternListIdentifiers(pattern.Elements)
+	}
+	return nil
+}
+
+func patternListIdentifiers(patterns []ast.Expression) []*ast.Identifier {
+	idents := []*ast.Identifier{}
+	for _, el := range patterns {
+		idents = append(idents, patternIdentifiers(el)...)
+	}
+	return idents
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"lang/ast"
 	"lang/lexer"
+	"lang/token"
+	"strings"
 	"testing"
 )
 
@@ -349,6 +351,18 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a + f(b)? * c",
+			"(a + ((f(b)?) * c))",
+		},
+		{
+			"-a.b?",
+			"(-(a.b?))",
+		},
+		{
+			"x = a.b(c).d + 1",
+			"x = (a.b(c).d + 1)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -840,6 +854,50 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestParsingInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"count: ${n + 1}"`, `"count: ${(n + 1)}"`},
+		{`"${a}${b}"`, `"${a}${b}"`},
+		{`"x: ${f({"k": [1]})["k"]}, y: ${"${y}"}."`, `"x: ${(f({k:[1]})[k])}, y: ${"${y}"}."`},
+		{`"a ${1}" + "b"`, `("a ${1}" + b)`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	program := New(lexer.New(`"a ${x} b"`)).ParseProgram()
+	is, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InterpolatedString)
+	if !ok || len(is.Parts) != 3 {
+		t.Fatalf("expected an *ast.InterpolatedString w/ 3 parts, got=%#v", program.Statements[0])
+	}
+	if text, ok := is.Parts[2].(*ast.StringLiteral); !ok || text.Value != " b" {
+		t.Errorf("expected the text %q, got=%#v", " b", is.Parts[2])
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`"a ${} b"`, "empty interpolation in string"},
+		{`"a ${x y} b"`, "Expected next token type to be 'TEMPLATE_TAIL', found 'IDENT'"},
+	}
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%s: expected=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	l := lexer.New(input)
@@ -959,3 +1017,659 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestParsingMatchExpression(t *testing.T) {
+	input := `match (x) { :ok => 1, null => { 2 } _ => y }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	match, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.MatchExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, match.Subject, "x") {
+		return
+	}
+	if len(match.Arms) != 3 {
+		t.Fatalf("match.Arms has wrong length. got=%d", len(match.Arms))
+	}
+	symbol, ok := match.Arms[0].Pattern.(*ast.SymbolLiteral)
+	if !ok || symbol.Value != "ok" {
+		t.Errorf("first pattern is not :ok. got=%s", match.Arms[0].Pattern)
+	}
+	if _, ok := match.Arms[1].Pattern.(*ast.NullLiteral); !ok {
+		t.Errorf("second pattern is not null. got=%T", match.Arms[1].Pattern)
+	}
+	testIdentifier(t, match.Arms[2].Pattern, "_")
+
+	for i, arm := range match.Arms {
+		if len(arm.Body.Statements) != 1 {
+			t.Errorf("arm %d body has wrong length. got=%d", i, len(arm.Body.Statements))
+		}
+	}
+}
+
+func TestParsingStructAndImpl(t *testing.T) {
+	input := `struct Point { x, y };
+impl Point { fn norm(self) { self.x * self.x } }
+p.x = p.y = 1;`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d",
+			len(program.Statements))
+	}
+
+	st, ok := program.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.StructStatement. got=%T", program.Statements[0])
+	}
+	if st.String() != "struct Point {x, y}" {
+		t.Errorf("struct.String() wrong. got=%q", st.String())
+	}
+
+	impl, ok := program.Statements[1].(*ast.ImplStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ImplStatement. got=%T", program.Statements[1])
+	}
+	if len(impl.Methods) != 1 || impl.Methods[0].Name.Value != "norm" {
+		t.Fatalf("impl has wrong methods. got=%s", impl.String())
+	}
+	if body := impl.Methods[0].Function.Body.String(); body != "(self.x * self.x)" {
+		t.Errorf("method body wrong. got=%q", body)
+	}
+
+	stmt := program.Statements[2].(*ast.ExpressionStatement)
+	if stmt.Expression.String() != "p.x = p.y = 1" {
+		t.Errorf("assignment wrong. got=%q", stmt.Expression.String())
+	}
+	assign, ok := stmt.Expression.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.AssignExpression. got=%T", stmt.Expression)
+	}
+	if _, ok := assign.Value.(*ast.AssignExpression); !ok {
+		t.Errorf("assignment is not right associative. got=%T", assign.Value)
+	}
+}
+
+func TestParsingEnumStatement(t *testing.T) {
+	input := `enum Shape { Circle(r), Rect(w, h), Empty };`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.EnumStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.EnumStatement. got=%T", program.Statements[0])
+	}
+	if len(stmt.Variants) != 3 {
+		t.Fatalf("enum has wrong number of variants. got=%d", len(stmt.Variants))
+	}
+	if stmt.String() != "enum Shape {Circle(r), Rect(w, h), Empty}" {
+		t.Errorf("enum.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestParsingTryExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() } catch (e) { g(e) } finally { h() }", "try f() catch (e) g(e) finally h()"},
+		{"try { f() } catch { 1 }", "try f() catch 1"},
+		{"try { f() } finally { throw x; }", "try f() finally throw x;"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.TryExpression); !ok {
+			t.Fatalf("exp is not ast.TryExpression. got=%T", stmt.Expression)
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	p := New(lexer.New("try { 1 }"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for try w/o catch or finally")
+	}
+}
+
+func TestParsingDeferStatement(t *testing.T) {
+	l := lexer.New("fn() { defer close(f); 1 }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if actual := program.String(); actual != "fn()defer close(f);1" {
+		t.Errorf("expected=%q, got=%q", "fn()defer close(f);1", actual)
+	}
+
+	for _, input := range []string{"defer f();", "fn() { defer 1 }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestParsingFunctionStatement(t *testing.T) {
+	l := lexer.New("fn add(a, b) { a + b } let f = fn twice(x) { x * 2 };")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.FunctionStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Name.Value != "add" || stmt.Function.Name != "add" {
+		t.Errorf("wrong name. got=%q", stmt.Name.Value)
+	}
+	if actual := program.String(); actual != "fn add(a, b)(a + b)let f = fn twice(x)(x * 2);" {
+		t.Errorf("got=%q", actual)
+	}
+}
+
+func TestConstAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		error bool
+	}{
+		{"const x = 1; x = 2;", true},
+		{"const x = 1; if (true) { x = 2 }", true},
+		{"const x = 1; fn f() { x = 2 }", true},
+		{"const x = 1; fn f(x) { x = 2 }", false},
+		{"const x = 1; if (true) { let x = 0; x = 2 }", false},
+		{"const x = 1; match (y) { x => x = 2 }", false},
+		{"const e = 1; try { f() } catch (e) { e = 2 }", false},
+		{"let x = 1; x = 2;", false},
+		{"const x = 1; let x = 2;", true},
+		{"const x = 1; const x = 2;", true},
+		{"const f = 1; fn f() { 2 }", true},
+		{"const x = 1; if (true) { const x = 2 }", false},
+		{"let x = 1; const x = 2;", false},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if got := len(p.Errors()) > 0; got != tt.error {
+			t.Errorf("%q: expected error=%t, got errors %v", tt.input, tt.error, p.Errors())
+		}
+	}
+}
+
+func TestTailCallMarking(t *testing.T) {
+	input := `fn f(n) {
+		g(1);
+		let x = g(2);
+		if (n) { return g(3) }
+		try { return g(4) } catch { 0 }
+		if (n) { g(5) } else { match (n) { _ => g(6) } }
+	}`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	tail := map[string]bool{}
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.ExpressionStatement:
+			walk(node.Expression)
+		case *ast.LetStatement:
+			walk(node.Value)
+		case *ast.ReturnStatement:
+			walk(node.ReturnValue)
+		case *ast.IfExpression:
+			walk(node.Consequence)
+			if node.Alternative != nil {
+				walk(node.Alternative)
+			}
+		case *ast.TryExpression:
+			walk(node.Block)
+		case *ast.MatchExpression:
+			for _, arm := range node.Arms {
+				walk(arm.Body)
+			}
+		case *ast.CallExpression:
+			tail[node.String()] = node.Tail
+		}
+	}
+	walk(program.Statements[0].(*ast.FunctionStatement).Function.Body)
+
+	expected := map[string]bool{
+		"g(1)": false, "g(2)": false, "g(3)": true,
+		"g(4)": false, "g(5)": true, "g(6)": true,
+	}
+	for call, want := range expected {
+		if tail[call] != want {
+			t.Errorf("%s: expected tail=%t, got=%t", call, want, tail[call])
+		}
+	}
+}
+
+func TestParsingGenerators(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		generator bool
+	}{
+		{"fn* count(n) { yield n }", "fn* count(n)(yield n)", true},
+		{"fn count(n) { yield n; }", "fn* count(n)(yield n)", true},
+		{"let g = fn*() { 1 };", "let g = fn*()1;", true},
+		{"fn*() { 1 }()", "fn*()1()", true},
+		{"fn f() { fn() { yield 1 } }", "fn f()fn*()(yield 1)", false},
+		{"for (x in xs) { puts(x) }", "for (x in xs) puts(x)", false},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+		if stmt, ok := program.Statements[0].(*ast.FunctionStatement); ok && stmt.Function.Generator != tt.generator {
+			t.Errorf("%q: expected generator=%t", tt.input, tt.generator)
+		}
+	}
+
+	p := New(lexer.New("yield 1"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for yield outside function")
+	}
+}
+
+func TestParsingConcurrency(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let t = spawn f(1, 2); await t", "let t = (spawn f(1, 2));(await t)"},
+		{"await spawn f() + 1", "((await (spawn f())) + 1)"},
+		{"select { v = recv(a) => v, send(b, 1) => { 2 }, recv(c) => 3, _ => 4 }",
+			"select {v = recv(a) => v, send(b, 1) => 2, recv(c) => 3, _ => 4}"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	for _, input := range []string{"spawn 1", "select { f(a) => 1 }", "select { _ => 1 }", "select { v = send(a, 1) => 1 }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestParsingImportExport(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/math.mk" as m;`, `import "lib/math.mk" as m;`},
+		{`import "util"`, `import "util";`},
+		{`export let x = 1;`, `export let x = 1;`},
+		{`export fn f() { 1 }`, `export fn f()1`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	for _, input := range []string{`fn f() { import "x" }`, `if (true) { export let x = 1; }`, `export 1 + 2`, `import x`} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestParsingTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x: int = 1;`, `let x: int = 1;`},
+		{`let xs: array[int] = [];`, `let xs: array[int] = [];`},
+		{`let h: hash[string, array[int]] = {};`, `let h: hash[string, array[int]] = {};`},
+		{`let x: int | null = null;`, `let x: int | null = null;`},
+		{`let f: fn(int, string) -> bool = g;`, `let f: fn(int, string) -> bool = g;`},
+		{`fn(a: int, b) -> int { a }`, `fn(a: int, b) -> int a`},
+		{`fn add(a: int, b: int) -> int { a + b }`, `fn add(a: int, b: int) -> int (a + b)`},
+		{`impl P { fn len(self) -> int { 0 } }`, `impl P {fn len(self) -> int 0}`},
+		{`a - b`, `(a - b)`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	for _, input := range []string{`let x: = 1`, `let x: array[] = 1`, `fn(a: ) {}`, `fn() -> {}`} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestParsingMacroLiteral(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T", stmt.Expression)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d", len(macro.Parameters))
+	}
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+	if macro.String() != "macro(x, y)(x + y)" {
+		t.Errorf("macro.String() wrong. got=%q", macro.String())
+	}
+}
+
+func TestParsingInfixDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`infix <> (precedence: SUM, assoc: left) = fn(a, b) { a }; 1 <> 2 <> 3`,
+			`infix <> (precedence: SUM, assoc: left) = fn(a, b)a;((1 <> 2) <> 3)`},
+		{`infix ** (precedence: PRODUCT, assoc: right) = pow; 1 ** 2 ** 3`,
+			`infix ** (precedence: PRODUCT, assoc: right) = pow;(1 ** (2 ** 3))`},
+		{`infix +++ (precedence: SUM) = f; 1 +++ 2 * 3 == 7`,
+			`infix +++ (precedence: SUM, assoc: left) = f;((1 +++ (2 * 3)) == 7)`},
+		{`infix %% (precedence: PRODUCT) = f; 1 + 2 %% 3`,
+			`infix %% (precedence: PRODUCT, assoc: left) = f;(1 + (2 %% 3))`},
+		{`infix <=> (precedence: EQUALS) = fn(a, b) { a <=> b }`,
+			`infix <=> (precedence: EQUALS, assoc: left) = fn(a, b)(a <=> b);`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	p := New(lexer.New(`1 <> 2`), WithOperators(Operator{Symbol: "<>", Precedence: SUM}))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != "(1 <> 2)" {
+		t.Errorf("expected=%q, got=%q", "(1 <> 2)", program.String())
+	}
+
+	for _, input := range []string{
+		`infix == (precedence: SUM) = f`,
+		`infix <a> (precedence: SUM) = f`,
+		`infix < > (precedence: SUM) = f`,
+		`infix <> = f`,
+		`infix <> (precedence: HIGH) = f`,
+		`infix <> (precedence: SUM, assoc: up) = f`,
+		`fn g() { infix <> (precedence: SUM) = f }`,
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+type unlessExpression struct {
+	ast.Custom
+	Token       token.Token
+	Condition   ast.Expression
+	Consequence *ast.BlockStatement
+}
+
+func (ue *unlessExpression) TokenLiteral() string { return ue.Token.Literal }
+func (ue *unlessExpression) String() string {
+	return "unless " + ue.Condition.String() + " " + ue.Consequence.String()
+}
+
+type assertStatement struct {
+	ast.Custom
+	Token token.Token
+	Value ast.Expression
+}
+
+func (as *assertStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *assertStatement) String() string       { return "assert " + as.Value.String() + ";" }
+
+var testExtension = ExtensionFunc(func(p *Parser) {
+	p.RegisterKeyword("unless", "UNLESS")
+	p.RegisterKeyword("assert", "ASSERT")
+	p.RegisterKeyword("orelse", "ORELSE")
+
+	p.RegisterPrefix("UNLESS", func() ast.Expression {
+		ue := &unlessExpression{Token: p.CurToken()}
+		p.NextToken()
+		ue.Condition = p.ParseExpression(LOWEST)
+		if !p.ExpectPeek(token.LBRACE) {
+			return nil
+		}
+		ue.Consequence = p.ParseBlockStatement()
+		return ue
+	})
+	p.RegisterInfix("ORELSE", EQUALS, func(left ast.Expression) ast.Expression {
+		ie := &ast.InfixExpression{Token: p.CurToken(), Operator: "orelse", Left: left}
+		p.NextToken()
+		ie.Right = p.ParseExpression(EQUALS)
+		return ie
+	})
+	p.RegisterStatement("ASSERT", func() ast.Statement {
+		as := &assertStatement{Token: p.CurToken()}
+		p.NextToken()
+		as.Value = p.ParseExpression(LOWEST)
+		if as.Value == nil {
+			p.Errorf("assert needs an expression")
+			return nil
+		}
+		if p.PeekToken().Type == token.SEMICOLON {
+			p.NextToken()
+		}
+		return as
+	})
+})
+
+func TestParsingExtensions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`unless x > 1 { y }`, `unless (x > 1) y`},
+		{`a orelse b orelse c + 1`, `((a orelse b) orelse (c + 1))`},
+		{`assert f(unless x { 1 });`, `assert f(unless x 1);`},
+		{`let unlessy = 1; unlessy`, `let unlessy = 1;unlessy`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input), WithExtensions(testExtension))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	p := New(lexer.New(`assert;`), WithExtensions(testExtension))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for an empty assert")
+	}
+
+	// without the extension the keywords are plain identifiers
+	p = New(lexer.New(`unless + assert`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != "(unless + assert)" {
+		t.Errorf("expected=%q, got=%q", "(unless + assert)", program.String())
+	}
+}
+
+func TestParsingIndentation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`def hi(a: int, b: int) -> Option[int]:
+    if a > b:
+        return Some(a + b)
+    return None
+
+hi(10, 10)
+`, `fn hi(a: int, b: int) -> Option[int] { if (a > b) { return Some(a + b) } return None } hi(10, 10)`},
+		{`let sign = fn(n):
+    if n < 0:
+        -1
+    elif n == 0:
+        0
+    else:
+        1
+sign(-2)`, `let sign = fn(n) { if (n < 0) { -1 } else { if (n == 0) { 0 } else { 1 } } }; sign(-2)`},
+		{`for (x in [1,
+           2]):
+    try:
+        f(x)
+    catch (e):
+        // ignored
+        g(e)
+    finally:
+        h()
+let xs = map([1], fn(x) { x * 2 })
+(xs)`, `for (x in [1, 2]) { try { f(x) } catch (e) { g(e) } finally { h() } } let xs = map([1], fn(x) { x * 2 }); (xs)`},
+		{`def outer():
+    def inner():
+        1
+    inner() + 1`, `fn outer() { fn inner() { 1 } inner() + 1 }`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input), WithIndentation())
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		braces := New(lexer.New(tt.expected))
+		expected := braces.ParseProgram()
+		checkParserErrors(t, braces)
+
+		actual := strings.Replace(program.String(), "def ", "fn ", -1)
+		if actual != expected.String() {
+			t.Errorf("expected=%q, got=%q", expected.String(), actual)
+		}
+	}
+
+	for _, input := range []string{
+		"def f():\n1",
+		"def f(): 1",
+		"if x:\n    a\n  b",
+	} {
+		p := New(lexer.New(input), WithIndentation())
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+
+	if _, ok := NewFile("x.mki", "def f():\n    1").ParseProgram().Statements[0].(*ast.FunctionStatement); !ok {
+		t.Errorf("expected .mki files to use the indentation syntax")
+	}
+}
+
+// Printing a program as source then parsing it again gives the same program
+func TestSourceRoundTrip(t *testing.T) {
+	tests := []string{
+		`let x = "hi there"; x`,
+		`try { throw x } catch (e) { 1 } finally { 2 }`,
+		`fn f(a: int) -> int { a }`,
+		`let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }; fib(10)`,
+		`struct P { x, y } impl P { fn get(self) -> int { self.x } fn none(self) {} } let p = P(1, 2); p.x = -(2 + 3); p.get()`,
+		`enum E { A, B(x), C() } match (E.B(1)) { E.B(x) => x, E.A => { let y = 0; y }, _ => {} }`,
+		`let h = {:a: [1, 2]}; let g = {"b": fn*() { yield 1 }}; h[:a][0]; {x: :y}["x"]`,
+		`for (x in [1, 2]) { try { throw x } catch { 0 } }`,
+		`let c = channel(1); select { v = recv(c) => v, send(c, 1) => { :sent }, _ => null }`,
+		`const f: fn(int, string) -> array[int | string] = fn(a: int, b: string) { [a, b] };`,
+		`let n = 1; "${n}: \${n} costs $5 ${"inner ${[n]}"}"`,
+		`infix ** (precedence: PRODUCT, assoc: right) = fn(a, b) { a * b }; 2 ** 3 ** 4`,
+		`fn* gen() { let x = yield 1; (yield 2) + x } let t = spawn gen(); (await t) + await f()?`,
+		`(fn g() { 1 }); (fn() { 2 })(); !(x = 2); if (a) { b } else { if (c) { d } }`,
+		`import "m" as m; export let y = m.z?; export fn z() { defer g(); 1 } export struct Q {}`,
+		`let m = macro(a, b) { quote(unquote(a) + unquote(b)) }; m(1, 2)`,
+	}
+	for _, input := range tests {
+		p := New(lexer.New(input))
+		expected := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		printed, err := ast.Source(expected)
+		if err != nil {
+			t.Fatalf("%s: %s", input, err)
+		}
+		p = New(lexer.New(printed))
+		actual := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("printed source doesn't parse: %s\n%q", p.Errors(), printed)
+			continue
+		}
+		if actual.String() != expected.String() {
+			t.Errorf("expected=%q, got=%q", expected.String(), actual.String())
+		}
+		if reprinted, _ := ast.Source(actual); reprinted != printed {
+			t.Errorf("expected=%q, got=%q", printed, reprinted)
+		}
+	}
+
+	program := New(lexer.New(`fn f(a: int) -> int { a } let x = "hi there"; x`)).ParseProgram()
+	expected := "fn f(a: int) -> int {\n\ta\n}\nlet x = \"hi there\";\nx"
+	if printed, _ := ast.Source(program); printed != expected {
+		t.Errorf("expected=%q, got=%q", expected, printed)
+	}
+
+	quoted := &ast.StringLiteral{Value: `say "hi"`}
+	if _, err := ast.Source(quoted); err == nil {
+		t.Errorf("expected an error printing a string w/ a double quote")
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"lang/lexer"
 	"lang/object"
 	"lang/parser"
+	"lang/typecheck"
+	"os"
 )
 
 const PROMPT = ">> "
@@ -15,6 +17,9 @@ const PROMPT = ">> "
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
+	macroEnv := object.NewEnvironment()
+	// Operators declared on earlier lines
+	var operators []parser.Operator
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -26,15 +31,27 @@ func Start(in io.Reader, out io.Writer) {
 		line := scanner.Text()
 		l := lexer.New(line)
 
-		p := parser.New(l)
+		p := parser.New(l, parser.WithOperators(operators...))
 
 		program := p.ParseProgram()
+		operators = p.Operators()
 		if len(p.Errors()) != 0 {
 			handleParserErrors(out, p.Errors())
 			continue
 		}
+		evaluator.DefineMacros(program, macroEnv)
+		program, err := evaluator.ExpandMacros(program, macroEnv)
+		if err != nil {
+			handleRuntimeError(out, err)
+			continue
+		}
+		handleTypeDiagnostics(out, typecheck.Check(program))
 
 		if evaluated := evaluator.Eval(program, env); evaluated != nil {
+			if err, ok := evaluated.(*object.Error); ok {
+				handleRuntimeError(out, err)
+				continue
+			}
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
 		}
@@ -42,9 +59,55 @@ func Start(in io.Reader, out io.Writer) {
 
 }
 
+// RunFile evaluates the script at path, reporting errors to out. It returns
+// false if the script failed to parse or ended w/ an error
+func RunFile(path string, out io.Writer) bool {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return false
+	}
+
+	p := parser.NewFile(path, string(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		handleParserErrors(out, p.Errors())
+		return false
+	}
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	program, expandErr := evaluator.ExpandMacros(program, macroEnv)
+	if expandErr != nil {
+		handleRuntimeError(out, expandErr)
+		return false
+	}
+	handleTypeDiagnostics(out, typecheck.Check(program))
+
+	if err, ok := evaluator.Eval(program, evaluator.ScriptEnvironment(path)).(*object.Error); ok {
+		handleRuntimeError(out, err)
+		return false
+	}
+	return true
+}
+
 func handleParserErrors(out io.Writer, errors []string) {
 	io.WriteString(out, " parser errors:\n")
 	for _, msg := range errors {
 		io.WriteString(out, "\t"+msg+"\n")
 	}
 }
+
+// handleTypeDiagnostics reports type errors as warnings: annotations never
+// stop a program from running
+func handleTypeDiagnostics(out io.Writer, diagnostics []typecheck.Diagnostic) {
+	for _, d := range diagnostics {
+		io.WriteString(out, "warning: "+d.String()+"\n")
+	}
+}
+
+func handleRuntimeError(out io.Writer, err *object.Error) {
+	io.WriteString(out, err.Inspect()+"\n")
+	for _, line := range err.Trace() {
+		io.WriteString(out, "\t"+line+"\n")
+	}
+}
@@ -0,0 +1,888 @@
+package sexpr
+
+import (
+	"lang/ast"
+	"lang/lexer"
+	"lang/token"
+	"strconv"
+	"strings"
+)
+
+// The forms below mirror the parser: each builds the node the equivalent
+// source would parse to, w/ tokens of the same types and literals positioned
+// at the form. Any list whose head isn't one of them is a call
+
+// statement reads a form in statement position: a declaration, or any
+// expression
+func (r *Reader) statement(f *form) ast.Statement {
+	switch head(f) {
+	case "let", "const":
+		return r.let(f)
+	case "return":
+		if !r.want(f, 1, 1) {
+			return nil
+		}
+		stmt := &ast.ReturnStatement{Token: r.at(f.list[0], token.RETURN)}
+		if stmt.ReturnValue = r.expression(f.list[1]); stmt.ReturnValue == nil {
+			return nil
+		}
+		return stmt
+	case "throw":
+		if !r.want(f, 1, 1) {
+			return nil
+		}
+		stmt := &ast.ThrowStatement{Token: r.at(f.list[0], token.THROW)}
+		if stmt.Value = r.expression(f.list[1]); stmt.Value == nil {
+			return nil
+		}
+		return stmt
+	case "defer":
+		if !r.want(f, 1, 1) {
+			return nil
+		}
+		if len(r.functions) == 0 {
+			r.errorf(f, "defer outside function")
+			return nil
+		}
+		stmt := &ast.DeferStatement{Token: r.at(f.list[0], token.DEFER)}
+		if stmt.Call = r.call(f.list[1], "defer"); stmt.Call == nil {
+			return nil
+		}
+		return stmt
+	case "struct":
+		return r.structStatement(f)
+	case "enum":
+		return r.enumStatement(f)
+	case "impl":
+		return r.implStatement(f)
+	case "import":
+		return r.importStatement(f)
+	case "export":
+		return r.exportStatement(f)
+	case "infix":
+		return r.infixStatement(f)
+	case "fn", "fn*":
+		if len(f.list) > 1 && f.list[1].open == 0 && !f.list[1].str {
+			fl := r.function(f)
+			if fl == nil {
+				return nil
+			}
+			stmt := &ast.FunctionStatement{Token: fl.Token, Function: fl}
+			stmt.Name = &ast.Identifier{Token: fl.Token, Value: fl.Name}
+			return stmt
+		}
+	}
+
+	expr := r.expression(f)
+	if expr == nil {
+		return nil
+	}
+	return &ast.ExpressionStatement{Expression: expr}
+}
+
+// expression reads a form in expression position
+func (r *Reader) expression(f *form) ast.Expression {
+	switch {
+	case f.str:
+		return &ast.StringLiteral{Token: r.literal(f, token.STRING, f.atom), Value: f.atom}
+	case f.open == 0:
+		return r.atom(f)
+	case f.open == '[':
+		array := &ast.ArrayLiteral{Token: r.literal(f, token.LBRACKET, "[")}
+		if array.Elements = r.expressions(f.list); array.Elements == nil {
+			return nil
+		}
+		return array
+	case f.open == '{':
+		return r.hash(f)
+	case len(f.list) == 0:
+		r.errorf(f, "empty list")
+		return nil
+	}
+
+	switch op := head(f); op {
+	case "fn", "fn*":
+		if fl := r.function(f); fl != nil {
+			return fl
+		}
+		return nil
+	case "macro":
+		return r.macro(f)
+	case "if":
+		return r.ifExpression(f)
+	case "match":
+		return r.match(f)
+	case "try":
+		return r.try(f)
+	case "for":
+		return r.forExpression(f)
+	case "select":
+		return r.selectExpression(f)
+	case "yield":
+		if !r.want(f, 1, 1) {
+			return nil
+		}
+		if len(r.functions) == 0 {
+			r.errorf(f, "yield outside function")
+			return nil
+		}
+		// Any function that yields is a generator, fn* or not
+		r.functions[len(r.functions)-1].Generator = true
+		expr := &ast.YieldExpression{Token: r.at(f.list[0], token.YIELD)}
+		if expr.Value = r.expression(f.list[1]); expr.Value == nil {
+			return nil
+		}
+		return expr
+	case "await":
+		if !r.want(f, 1, 1) {
+			return nil
+		}
+		expr := &ast.AwaitExpression{Token: r.at(f.list[0], token.AWAIT)}
+		if expr.Value = r.expression(f.list[1]); expr.Value == nil {
+			return nil
+		}
+		return expr
+	case "spawn":
+		if !r.want(f, 1, 1) {
+			return nil
+		}
+		expr := &ast.SpawnExpression{Token: r.at(f.list[0], token.SPAWN)}
+		if expr.Call = r.call(f.list[1], "spawn"); expr.Call == nil {
+			return nil
+		}
+		return expr
+	case ".":
+		if !r.want(f, 2, 2) {
+			return nil
+		}
+		expr := &ast.MemberExpression{Token: r.at(f.list[0], token.DOT)}
+		expr.Object = r.expression(f.list[1])
+		expr.Property = r.identifier(f.list[2])
+		if expr.Object == nil || expr.Property == nil {
+			return nil
+		}
+		return expr
+	case "[]":
+		if !r.want(f, 2, 2) {
+			return nil
+		}
+		expr := &ast.IndexExpression{Token: r.literal(f.list[0], token.LBRACKET, "[")}
+		expr.Left = r.expression(f.list[1])
+		expr.Index = r.expression(f.list[2])
+		if expr.Left == nil || expr.Index == nil {
+			return nil
+		}
+		return expr
+	case "?":
+		if !r.want(f, 1, 1) {
+			return nil
+		}
+		expr := &ast.PropagateExpression{Token: r.at(f.list[0], token.QUESTION)}
+		if expr.Left = r.expression(f.list[1]); expr.Left == nil {
+			return nil
+		}
+		return expr
+	case "=":
+		return r.assign(f)
+	default:
+		if op == "$" && len(f.list) != 3 {
+			return r.interpolated(f)
+		}
+		if isOperator(op) {
+			return r.operator(f)
+		}
+		if isKeyword(op) {
+			r.errorf(f, "%s is not an expression", op)
+			return nil
+		}
+	}
+
+	expr := &ast.CallExpression{Token: r.literal(f, token.LPAREN, "(")}
+	expr.Function = r.expression(f.list[0])
+	expr.Arguments = r.expressions(f.list[1:])
+	if expr.Function == nil || expr.Arguments == nil {
+		return nil
+	}
+	return expr
+}
+
+// expressions reads each of forms as an expression. It returns nil if any
+// of them is malformed
+func (r *Reader) expressions(forms []*form) []ast.Expression {
+	exprs := []ast.Expression{}
+	ok := true
+	for _, f := range forms {
+		expr := r.expression(f)
+		ok = ok && expr != nil
+		exprs = append(exprs, expr)
+	}
+	if !ok {
+		return nil
+	}
+	return exprs
+}
+
+func (r *Reader) atom(f *form) ast.Expression {
+	switch {
+	case f.atom == "true":
+		return &ast.Boolean{Token: r.at(f, token.TRUE), Value: true}
+	case f.atom == "false":
+		return &ast.Boolean{Token: r.at(f, token.FALSE), Value: false}
+	case f.atom == "null":
+		return &ast.NullLiteral{Token: r.at(f, token.NULL)}
+	case f.atom == "[]":
+		return &ast.ArrayLiteral{Token: r.literal(f, token.LBRACKET, "["), Elements: []ast.Expression{}}
+	case strings.HasPrefix(f.atom, ":") && isName(f.atom[1:]):
+		return &ast.SymbolLiteral{Token: r.literal(f, token.SYMBOL, f.atom[1:]), Value: f.atom[1:]}
+	}
+	if value, err := strconv.ParseInt(f.atom, 10, 64); err == nil {
+		return &ast.IntegerLiteral{Token: r.at(f, token.INT), Value: value}
+	}
+	if ident := r.identifier(f); ident != nil {
+		return ident
+	}
+	return nil
+}
+
+func (r *Reader) identifier(f *form) *ast.Identifier {
+	if f.open != 0 || f.str || !isName(f.atom) || isKeyword(f.atom) {
+		r.errorf(f, "expected an identifier, got %s", describe(f))
+		return nil
+	}
+	return &ast.Identifier{Token: r.at(f, token.IDENT), Value: f.atom}
+}
+
+// identifiers reads a list of names, e.g. struct fields
+func (r *Reader) identifiers(forms []*form) []*ast.Identifier {
+	idents := []*ast.Identifier{}
+	for _, f := range forms {
+		ident := r.identifier(f)
+		if ident == nil {
+			return nil
+		}
+		idents = append(idents, ident)
+	}
+	return idents
+}
+
+// `{k1 v1 k2 v2 ...}`
+func (r *Reader) hash(f *form) ast.Expression {
+	if len(f.list)%2 != 0 {
+		r.errorf(f, "hash needs a value for every key")
+		return nil
+	}
+	items := r.expressions(f.list)
+	if items == nil {
+		return nil
+	}
+	hash := &ast.HashLiteral{Token: r.literal(f, token.LBRACE, "{"), Pairs: map[ast.Expression]ast.Expression{}}
+	for ix := 0; ix < len(items); ix += 2 {
+		hash.Pairs[items[ix]] = items[ix+1]
+	}
+	return hash
+}
+
+// `(op x)` for - and !, `(op x y)` for any operator, user-defined ones
+// included
+func (r *Reader) operator(f *form) ast.Expression {
+	op := f.list[0].atom
+	tok := r.literal(f.list[0], operatorType(op), op)
+	switch {
+	case len(f.list) == 2 && (op == "-" || op == "!"):
+		expr := &ast.PrefixExpression{Token: tok, Operator: op}
+		if expr.Right = r.expression(f.list[1]); expr.Right == nil {
+			return nil
+		}
+		return expr
+	case len(f.list) == 3:
+		expr := &ast.InfixExpression{Token: tok, Operator: op}
+		expr.Left = r.expression(f.list[1])
+		expr.Right = r.expression(f.list[2])
+		if expr.Left == nil || expr.Right == nil {
+			return nil
+		}
+		return expr
+	}
+	r.errorf(f, "operator %s takes 2 operands, got %d", op, len(f.list)-1)
+	return nil
+}
+
+// `($ "text" expr "text" ...)`, alternating text and embedded expressions.
+// `($ x y)` is left to the operator, in case $ is declared as one
+func (r *Reader) interpolated(f *form) ast.Expression {
+	if len(f.list)%2 != 0 {
+		r.errorf(f, "interpolated string needs text around each expression, got %d parts", len(f.list)-1)
+		return nil
+	}
+	parts := r.expressions(f.list[1:])
+	if parts == nil {
+		return nil
+	}
+	for ix := 0; ix < len(parts); ix += 2 {
+		if !f.list[ix+1].str {
+			r.errorf(f.list[ix+1], "expected text, got %s", describe(f.list[ix+1]))
+			return nil
+		}
+	}
+	return &ast.InterpolatedString{Token: r.literal(f.list[0], token.TEMPLATE_HEAD, f.list[1].atom), Parts: parts}
+}
+
+// `(= target value)`
+func (r *Reader) assign(f *form) ast.Expression {
+	if !r.want(f, 2, 2) {
+		return nil
+	}
+	expr := &ast.AssignExpression{Token: r.at(f.list[0], token.ASSIGN)}
+	if expr.Target = r.expression(f.list[1]); expr.Target == nil {
+		return nil
+	}
+	switch expr.Target.(type) {
+	case *ast.Identifier, *ast.MemberExpression:
+	default:
+		r.errorf(f.list[1], "cannot assign to %s", expr.Target.String())
+		return nil
+	}
+	if expr.Value = r.expression(f.list[2]); expr.Value == nil {
+		return nil
+	}
+	return expr
+}
+
+// call reads the call a defer or spawn runs
+func (r *Reader) call(f *form, keyword string) *ast.CallExpression {
+	call, ok := r.expression(f).(*ast.CallExpression)
+	if !ok {
+		r.errorf(f, "%s takes a call, got %s", keyword, describe(f))
+		return nil
+	}
+	return call
+}
+
+// `(let name value)` or `(let (name type) value)`, and the same for const
+func (r *Reader) let(f *form) ast.Statement {
+	if !r.want(f, 2, 2) {
+		return nil
+	}
+	stmt := &ast.LetStatement{Token: r.at(f.list[0], token.LET), Const: f.list[0].atom == "const"}
+	if stmt.Const {
+		stmt.Token.Type = token.CONST
+	}
+	if stmt.Name, stmt.Type = r.binding(f.list[1]); stmt.Name == nil {
+		return nil
+	}
+	if stmt.Value = r.expression(f.list[2]); stmt.Value == nil {
+		return nil
+	}
+	return stmt
+}
+
+// binding reads a name w/ an optional type: `name` or `(name type)`
+func (r *Reader) binding(f *form) (*ast.Identifier, *ast.TypeExpr) {
+	if f.open != '(' {
+		return r.identifier(f), nil
+	}
+	if len(f.list) != 2 {
+		r.errorf(f, "expected (name type), got %s", describe(f))
+		return nil, nil
+	}
+	name, typ := r.identifier(f.list[0]), r.typeExpr(f.list[1])
+	if typ == nil {
+		return nil, nil
+	}
+	return name, typ
+}
+
+// typeExpr reads a type annotation: `int`, `(array int)`, `(Option int)`,
+// `(fn (int int) bool)` or a union, `(| int null)`
+func (r *Reader) typeExpr(f *form) *ast.TypeExpr {
+	if f.open == 0 && !f.str && (isName(f.atom) || f.atom == "null") {
+		return &ast.TypeExpr{Token: r.at(f, token.IDENT), Name: f.atom}
+	}
+	if f.open != '(' || len(f.list) == 0 {
+		r.errorf(f, "expected a type, got %s", describe(f))
+		return nil
+	}
+
+	t := &ast.TypeExpr{Token: r.at(f.list[0], token.IDENT), Name: f.list[0].atom}
+	args := f.list[1:]
+	switch {
+	case t.Name == "fn":
+		t.Token.Type = token.FUNCTION
+		if len(args) != 2 || args[0].open != '(' {
+			r.errorf(f, "expected (fn (params) result), got %s", describe(f))
+			return nil
+		}
+		t.Return = r.typeExpr(args[1])
+		args = args[0].list
+	case t.Name == "|":
+		t.Token.Type = token.PIPE
+	case !isName(t.Name):
+		r.errorf(f.list[0], "expected a type, got %s", describe(f.list[0]))
+		return nil
+	}
+	t.Params = []*ast.TypeExpr{}
+	for _, arg := range args {
+		param := r.typeExpr(arg)
+		if param == nil {
+			return nil
+		}
+		t.Params = append(t.Params, param)
+	}
+	if t.Name == "fn" && t.Return == nil {
+		return nil
+	}
+	return t
+}
+
+// `(fn name? (params) (-> type)? body...)`, where each parameter is a name
+// or `(name type)`. fn* declares a generator
+func (r *Reader) function(f *form) *ast.FunctionLiteral {
+	fl := &ast.FunctionLiteral{Token: r.literal(f.list[0], token.FUNCTION, "fn"), Generator: f.list[0].atom == "fn*"}
+	rest := f.list[1:]
+	if len(rest) > 0 && rest[0].open == 0 && !rest[0].str {
+		name := r.identifier(rest[0])
+		if name == nil {
+			return nil
+		}
+		fl.Name = name.Value
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || rest[0].open != '(' {
+		r.errorf(f, "expected a parameter list in %s", describe(f))
+		return nil
+	}
+
+	fl.Parameters = []*ast.Identifier{}
+	fl.ParamTypes = []*ast.TypeExpr{}
+	for _, param := range rest[0].list {
+		name, typ := r.binding(param)
+		if name == nil {
+			return nil
+		}
+		fl.Parameters = append(fl.Parameters, name)
+		fl.ParamTypes = append(fl.ParamTypes, typ)
+	}
+	rest = rest[1:]
+
+	if len(rest) > 0 && head(rest[0]) == "->" {
+		if !r.want(rest[0], 1, 1) {
+			return nil
+		}
+		if fl.ReturnType = r.typeExpr(rest[0].list[1]); fl.ReturnType == nil {
+			return nil
+		}
+		rest = rest[1:]
+	}
+
+	r.functions = append(r.functions, fl)
+	fl.Body = r.body(f, rest)
+	r.functions = r.functions[:len(r.functions)-1]
+	ast.MarkTailCalls(fl.Body)
+	return fl
+}
+
+// `(macro (params) body...)`
+func (r *Reader) macro(f *form) ast.Expression {
+	if len(f.list) < 2 || f.list[1].open != '(' {
+		r.errorf(f, "expected a parameter list in %s", describe(f))
+		return nil
+	}
+	ml := &ast.MacroLiteral{Token: r.at(f.list[0], token.MACRO)}
+	if ml.Parameters = r.identifiers(f.list[1].list); ml.Parameters == nil {
+		return nil
+	}
+	ml.Body = r.body(f, f.list[2:])
+	return ml
+}
+
+// block reads a form in block position: `(do statements...)`, or any single
+// statement
+func (r *Reader) block(f *form) *ast.BlockStatement {
+	if head(f) == "do" {
+		return r.body(f, f.list[1:])
+	}
+	return r.body(f, []*form{f})
+}
+
+func (r *Reader) body(f *form, forms []*form) *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: r.literal(f, token.LBRACE, "{"), Statements: []ast.Statement{}}
+	r.depth++
+	defer func() { r.depth-- }()
+	for _, f := range forms {
+		if stmt := r.statement(f); stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+	}
+	return block
+}
+
+// `(if condition then else?)`
+func (r *Reader) ifExpression(f *form) ast.Expression {
+	if !r.want(f, 2, 3) {
+		return nil
+	}
+	expr := &ast.IfExpression{Token: r.at(f.list[0], token.IF)}
+	if expr.Condition = r.expression(f.list[1]); expr.Condition == nil {
+		return nil
+	}
+	expr.Consequence = r.block(f.list[2])
+	if len(f.list) == 4 {
+		expr.Alternative = r.block(f.list[3])
+	}
+	return expr
+}
+
+// `(match subject (=> pattern body)...)`
+func (r *Reader) match(f *form) ast.Expression {
+	if len(f.list) < 2 {
+		r.errorf(f, "match takes a subject")
+		return nil
+	}
+	expr := &ast.MatchExpression{Token: r.at(f.list[0], token.MATCH)}
+	if expr.Subject = r.expression(f.list[1]); expr.Subject == nil {
+		return nil
+	}
+	for _, arm := range f.list[2:] {
+		if !r.arm(arm) {
+			return nil
+		}
+		pattern := r.expression(arm.list[1])
+		if pattern == nil {
+			return nil
+		}
+		expr.Arms = append(expr.Arms, &ast.MatchArm{Pattern: pattern, Body: r.block(arm.list[2])})
+	}
+	return expr
+}
+
+// arm checks f is a `(=> head body)` arm of a match or select
+func (r *Reader) arm(f *form) bool {
+	if head(f) != "=>" || len(f.list) != 3 {
+		r.errorf(f, "expected (=> pattern body), got %s", describe(f))
+		return false
+	}
+	return true
+}
+
+// `(try body (catch name? body)? (finally body)?)`
+func (r *Reader) try(f *form) ast.Expression {
+	if len(f.list) < 2 {
+		r.errorf(f, "try takes a body")
+		return nil
+	}
+	expr := &ast.TryExpression{Token: r.at(f.list[0], token.TRY), Block: r.block(f.list[1])}
+	for _, clause := range f.list[2:] {
+		switch {
+		case head(clause) == "catch" && expr.Catch == nil && expr.Finally == nil:
+			if !r.want(clause, 1, 2) {
+				return nil
+			}
+			if len(clause.list) == 3 {
+				if expr.CatchParam = r.identifier(clause.list[1]); expr.CatchParam == nil {
+					return nil
+				}
+			}
+			expr.Catch = r.block(clause.list[len(clause.list)-1])
+		case head(clause) == "finally" && expr.Finally == nil:
+			if !r.want(clause, 1, 1) {
+				return nil
+			}
+			expr.Finally = r.block(clause.list[1])
+		default:
+			r.errorf(clause, "expected a catch or finally clause, got %s", describe(clause))
+			return nil
+		}
+	}
+	if expr.Catch == nil && expr.Finally == nil {
+		r.errorf(f, "Expected catch or finally after try block")
+		return nil
+	}
+	return expr
+}
+
+// `(for name iterable body)`
+func (r *Reader) forExpression(f *form) ast.Expression {
+	if !r.want(f, 3, 3) {
+		return nil
+	}
+	expr := &ast.ForExpression{Token: r.at(f.list[0], token.FOR)}
+	expr.Variable = r.identifier(f.list[1])
+	expr.Iterable = r.expression(f.list[2])
+	if expr.Variable == nil || expr.Iterable == nil {
+		return nil
+	}
+	expr.Body = r.block(f.list[3])
+	return expr
+}
+
+// `(select (=> (recv ch) body) (=> (= name (recv ch)) body)
+// (=> (send ch value) body) (=> _ body))`
+func (r *Reader) selectExpression(f *form) ast.Expression {
+	expr := &ast.SelectExpression{Token: r.at(f.list[0], token.SELECT)}
+	for _, arm := range f.list[1:] {
+		if !r.arm(arm) {
+			return nil
+		}
+		if arm.list[1].atom == "_" && arm.list[1].open == 0 {
+			expr.Default = r.block(arm.list[2])
+			continue
+		}
+
+		sc := &ast.SelectCase{}
+		op := arm.list[1]
+		if head(op) == "=" && len(op.list) == 3 {
+			if sc.Binding = r.identifier(op.list[1]); sc.Binding == nil {
+				return nil
+			}
+			op = op.list[2]
+		}
+		switch {
+		case head(op) == "recv" && len(op.list) == 2:
+			sc.Channel = r.expression(op.list[1])
+		case head(op) == "send" && len(op.list) == 3 && sc.Binding == nil:
+			sc.Channel = r.expression(op.list[1])
+			sc.Value = r.expression(op.list[2])
+			if sc.Value == nil {
+				return nil
+			}
+		default:
+			r.errorf(arm.list[1], "invalid select case: %s", describe(arm.list[1]))
+			return nil
+		}
+		if sc.Channel == nil {
+			return nil
+		}
+		sc.Token = r.at(op.list[0], token.IDENT)
+		sc.Body = r.block(arm.list[2])
+		expr.Cases = append(expr.Cases, sc)
+	}
+	if len(expr.Cases) == 0 {
+		r.errorf(f, "select needs at least one recv or send case")
+		return nil
+	}
+	return expr
+}
+
+// `(struct Name fields...)`
+func (r *Reader) structStatement(f *form) ast.Statement {
+	if len(f.list) < 2 {
+		r.errorf(f, "struct takes a name")
+		return nil
+	}
+	stmt := &ast.StructStatement{Token: r.at(f.list[0], token.STRUCT)}
+	stmt.Name = r.identifier(f.list[1])
+	if stmt.Fields = r.identifiers(f.list[2:]); stmt.Name == nil || stmt.Fields == nil {
+		return nil
+	}
+	if len(stmt.Fields) == 0 {
+		stmt.Fields = nil
+	}
+	return stmt
+}
+
+// `(enum Name Unit (Variant fields...)...)`
+func (r *Reader) enumStatement(f *form) ast.Statement {
+	if len(f.list) < 2 {
+		r.errorf(f, "enum takes a name")
+		return nil
+	}
+	stmt := &ast.EnumStatement{Token: r.at(f.list[0], token.ENUM)}
+	if stmt.Name = r.identifier(f.list[1]); stmt.Name == nil {
+		return nil
+	}
+	for _, v := range f.list[2:] {
+		variant := &ast.EnumVariant{}
+		if v.open != '(' {
+			variant.Name = r.identifier(v)
+		} else if len(v.list) > 0 {
+			variant.Name = r.identifier(v.list[0])
+			if variant.Fields = r.identifiers(v.list[1:]); variant.Fields == nil {
+				return nil
+			}
+		}
+		if variant.Name == nil {
+			if v.open == '(' && len(v.list) == 0 {
+				r.errorf(v, "empty list")
+			}
+			return nil
+		}
+		stmt.Variants = append(stmt.Variants, variant)
+	}
+	return stmt
+}
+
+// `(impl Name (fn method (params) body...)...)`
+func (r *Reader) implStatement(f *form) ast.Statement {
+	if len(f.list) < 2 {
+		r.errorf(f, "impl takes a name")
+		return nil
+	}
+	stmt := &ast.ImplStatement{Token: r.at(f.list[0], token.IMPL)}
+	if stmt.Name = r.identifier(f.list[1]); stmt.Name == nil {
+		return nil
+	}
+	for _, m := range f.list[2:] {
+		if head(m) != "fn" || len(m.list) < 2 || m.list[1].open != 0 {
+			r.errorf(m, "expected a method, got %s", describe(m))
+			return nil
+		}
+		fl := r.function(m)
+		if fl == nil {
+			return nil
+		}
+		name := &ast.Identifier{Token: r.at(m.list[1], token.IDENT), Value: fl.Name}
+		stmt.Methods = append(stmt.Methods, &ast.MethodDefinition{Name: name, Function: fl})
+	}
+	return stmt
+}
+
+// `(import "path" alias?)`
+func (r *Reader) importStatement(f *form) ast.Statement {
+	if r.depth > 0 {
+		r.errorf(f, "import must be at the top level")
+		return nil
+	}
+	if !r.want(f, 1, 2) {
+		return nil
+	}
+	if !f.list[1].str {
+		r.errorf(f.list[1], "expected a path, got %s", describe(f.list[1]))
+		return nil
+	}
+	stmt := &ast.ImportStatement{Token: r.at(f.list[0], token.IMPORT), Path: f.list[1].atom}
+	if len(f.list) == 3 {
+		if stmt.Alias = r.identifier(f.list[2]); stmt.Alias == nil {
+			return nil
+		}
+	}
+	return stmt
+}
+
+// `(export statement)`
+func (r *Reader) exportStatement(f *form) ast.Statement {
+	if r.depth > 0 {
+		r.errorf(f, "export must be at the top level")
+		return nil
+	}
+	if !r.want(f, 1, 1) {
+		return nil
+	}
+	stmt := &ast.ExportStatement{Token: r.at(f.list[0], token.EXPORT)}
+	if stmt.Statement = r.statement(f.list[1]); stmt.Statement == nil {
+		return nil
+	}
+	if stmt.ExportedName() == "" {
+		r.errorf(f, "cannot export %s", stmt.Statement.String())
+		return nil
+	}
+	return stmt
+}
+
+// `(infix op precedence assoc function)`, e.g. `(infix <> SUM left f)`
+func (r *Reader) infixStatement(f *form) ast.Statement {
+	if r.depth > 0 {
+		r.errorf(f, "infix declarations must be at the top level")
+		return nil
+	}
+	if !r.want(f, 4, 4) {
+		return nil
+	}
+	op, precedence, assoc := f.list[1], f.list[2], f.list[3]
+	switch {
+	case op.open != 0 || op.str || !isOperator(op.atom):
+		r.errorf(op, "expected an operator, got %s", describe(op))
+		return nil
+	case precedence.open != 0 || precedence.str || !isName(precedence.atom):
+		r.errorf(precedence, "expected a precedence, got %s", describe(precedence))
+		return nil
+	case assoc.atom != "left" && assoc.atom != "right" || assoc.open != 0 || assoc.str:
+		r.errorf(assoc, "expected left or right, got %s", describe(assoc))
+		return nil
+	}
+	stmt := &ast.InfixStatement{
+		Token:      r.at(f.list[0], token.INFIX),
+		Operator:   op.atom,
+		Precedence: precedence.atom,
+		Assoc:      assoc.atom,
+	}
+	if stmt.Function = r.expression(f.list[4]); stmt.Function == nil {
+		return nil
+	}
+	return stmt
+}
+
+// want checks the list f has between min and max arguments after its head
+func (r *Reader) want(f *form, min, max int) bool {
+	if n := len(f.list) - 1; n < min || n > max {
+		noun := "arguments"
+		if max == 1 {
+			noun = "argument"
+		}
+		if min == max {
+			r.errorf(f, "%s takes %d %s, got %d", f.list[0].atom, min, noun, n)
+		} else {
+			r.errorf(f, "%s takes %d to %d %s, got %d", f.list[0].atom, min, max, noun, n)
+		}
+		return false
+	}
+	return true
+}
+
+// head is the atom at the start of a (...) list, "" if there isn't one
+func head(f *form) string {
+	if f.open != '(' || len(f.list) == 0 || f.list[0].open != 0 || f.list[0].str {
+		return ""
+	}
+	return f.list[0].atom
+}
+
+func describe(f *form) string {
+	switch {
+	case f.str:
+		return strconv.Quote(f.atom)
+	case f.open != 0:
+		return string(f.open) + "..." + string(closing[f.open])
+	}
+	return f.atom
+}
+
+// at is the token of type tt at the form, w/ the form's text as its literal
+func (r *Reader) at(f *form, tt token.TokenType) token.Token {
+	return r.literal(f, tt, f.atom)
+}
+
+func (r *Reader) literal(f *form, tt token.TokenType, lit string) token.Token {
+	return token.Token{Type: tt, Literal: lit, File: r.file, Line: f.line, Column: f.column}
+}
+
+// operatorType is the type of the token the lexer makes of op: its own for
+// the builtin operators, TokenType(op) for user-defined ones
+func operatorType(op string) token.TokenType {
+	if tok := lexer.New(op).NextToken(); tok.Literal == op {
+		return tok.Type
+	}
+	return token.TokenType(op)
+}
+
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for ix := 0; ix < len(s); ix++ {
+		ch := s[ix]
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// isKeyword reports whether s is reserved in the ordinary syntax, and so
+// can't name anything
+func isKeyword(s string) bool {
+	return isName(s) && lexer.LookupIdentifierType(s) != token.IDENT
+}
+
+func isOperator(s string) bool {
+	return s != "" && strings.Trim(s, "+-*/<>=!|&^%~?@$") == ""
+}
@@ -0,0 +1,257 @@
+package sexpr
+
+import (
+	"fmt"
+	"lang/ast"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Print writes node out as s-expressions, one top-level form per line for a
+// program. Reading the result back gives the same tree, up to positions.
+// Blocks always print as `(do ...)`, hash pairs in order of their printed
+// keys, so equal trees print the same. Nodes added by parser extensions
+// have no s-expression and make Print fail
+func Print(node ast.Node) (string, error) {
+	p := &printer{}
+	if program, ok := node.(*ast.Program); ok {
+		lines := []string{}
+		for _, stmt := range program.Statements {
+			lines = append(lines, p.node(stmt))
+		}
+		return strings.Join(lines, "\n"), p.err
+	}
+	return p.node(node), p.err
+}
+
+type printer struct {
+	err error
+}
+
+func list(items ...string) string {
+	return "(" + strings.Join(items, " ") + ")"
+}
+
+func (p *printer) expressions(exprs []ast.Expression) []string {
+	out := []string{}
+	for _, expr := range exprs {
+		out = append(out, p.node(expr))
+	}
+	return out
+}
+
+func (p *printer) statements(stmts []ast.Statement) []string {
+	out := []string{}
+	for _, stmt := range stmts {
+		out = append(out, p.node(stmt))
+	}
+	return out
+}
+
+func identifiers(idents []*ast.Identifier) []string {
+	out := []string{}
+	for _, ident := range idents {
+		out = append(out, ident.Value)
+	}
+	return out
+}
+
+func (p *printer) block(block *ast.BlockStatement) string {
+	return list(append([]string{"do"}, p.statements(block.Statements)...)...)
+}
+
+func (p *printer) node(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		return p.node(node.Expression)
+	case *ast.LetStatement:
+		keyword := "let"
+		if node.Const {
+			keyword = "const"
+		}
+		name := node.Name.Value
+		if node.Type != nil {
+			name = list(name, typeExpr(node.Type))
+		}
+		return list(keyword, name, p.node(node.Value))
+	case *ast.ReturnStatement:
+		return list("return", p.node(node.ReturnValue))
+	case *ast.ThrowStatement:
+		return list("throw", p.node(node.Value))
+	case *ast.DeferStatement:
+		return list("defer", p.node(node.Call))
+	case *ast.FunctionStatement:
+		return p.node(node.Function)
+	case *ast.StructStatement:
+		return list(append([]string{"struct", node.Name.Value}, identifiers(node.Fields)...)...)
+	case *ast.EnumStatement:
+		items := []string{"enum", node.Name.Value}
+		for _, v := range node.Variants {
+			if v.Fields == nil {
+				items = append(items, v.Name.Value)
+			} else {
+				items = append(items, list(append([]string{v.Name.Value}, identifiers(v.Fields)...)...))
+			}
+		}
+		return list(items...)
+	case *ast.ImplStatement:
+		items := []string{"impl", node.Name.Value}
+		for _, m := range node.Methods {
+			items = append(items, p.node(m.Function))
+		}
+		return list(items...)
+	case *ast.ImportStatement:
+		if node.Alias != nil {
+			return list("import", strconv.Quote(node.Path), node.Alias.Value)
+		}
+		return list("import", strconv.Quote(node.Path))
+	case *ast.ExportStatement:
+		return list("export", p.node(node.Statement))
+	case *ast.InfixStatement:
+		return list("infix", node.Operator, node.Precedence, node.Assoc, p.node(node.Function))
+	case *ast.BlockStatement:
+		return p.block(node)
+
+	case *ast.Identifier:
+		return node.Value
+	case *ast.IntegerLiteral:
+		return strconv.FormatInt(node.Value, 10)
+	case *ast.Boolean:
+		return strconv.FormatBool(node.Value)
+	case *ast.StringLiteral:
+		return strconv.Quote(node.Value)
+	case *ast.InterpolatedString:
+		return list(append([]string{"$"}, p.expressions(node.Parts)...)...)
+	case *ast.NullLiteral:
+		return "null"
+	case *ast.SymbolLiteral:
+		return ":" + node.Value
+	case *ast.PrefixExpression:
+		return list(node.Operator, p.node(node.Right))
+	case *ast.InfixExpression:
+		return list(node.Operator, p.node(node.Left), p.node(node.Right))
+	case *ast.AssignExpression:
+		return list("=", p.node(node.Target), p.node(node.Value))
+	case *ast.IndexExpression:
+		return list("[]", p.node(node.Left), p.node(node.Index))
+	case *ast.MemberExpression:
+		return list(".", p.node(node.Object), node.Property.Value)
+	case *ast.PropagateExpression:
+		return list("?", p.node(node.Left))
+	case *ast.CallExpression:
+		function := p.node(node.Function)
+		if function == "[]" {
+			// Calling an empty array, which `([] ...)` would read as indexing
+			function = "[ ]"
+		}
+		return list(append([]string{function}, p.expressions(node.Arguments)...)...)
+	case *ast.ArrayLiteral:
+		return "[" + strings.Join(p.expressions(node.Elements), " ") + "]"
+	case *ast.HashLiteral:
+		pairs := [][2]string{}
+		for k, v := range node.Pairs {
+			pairs = append(pairs, [2]string{p.node(k), p.node(v)})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+		items := []string{}
+		for _, pair := range pairs {
+			items = append(items, pair[0], pair[1])
+		}
+		return "{" + strings.Join(items, " ") + "}"
+	case *ast.FunctionLiteral:
+		return p.function(node)
+	case *ast.MacroLiteral:
+		items := []string{"macro", list(identifiers(node.Parameters)...)}
+		return list(append(items, p.statements(node.Body.Statements)...)...)
+	case *ast.IfExpression:
+		if node.Alternative != nil {
+			return list("if", p.node(node.Condition), p.block(node.Consequence), p.block(node.Alternative))
+		}
+		return list("if", p.node(node.Condition), p.block(node.Consequence))
+	case *ast.MatchExpression:
+		items := []string{"match", p.node(node.Subject)}
+		for _, arm := range node.Arms {
+			items = append(items, list("=>", p.node(arm.Pattern), p.block(arm.Body)))
+		}
+		return list(items...)
+	case *ast.TryExpression:
+		items := []string{"try", p.block(node.Block)}
+		switch {
+		case node.Catch != nil && node.CatchParam != nil:
+			items = append(items, list("catch", node.CatchParam.Value, p.block(node.Catch)))
+		case node.Catch != nil:
+			items = append(items, list("catch", p.block(node.Catch)))
+		}
+		if node.Finally != nil {
+			items = append(items, list("finally", p.block(node.Finally)))
+		}
+		return list(items...)
+	case *ast.ForExpression:
+		return list("for", node.Variable.Value, p.node(node.Iterable), p.block(node.Body))
+	case *ast.YieldExpression:
+		return list("yield", p.node(node.Value))
+	case *ast.AwaitExpression:
+		return list("await", p.node(node.Value))
+	case *ast.SpawnExpression:
+		return list("spawn", p.node(node.Call))
+	case *ast.SelectExpression:
+		items := []string{"select"}
+		for _, sc := range node.Cases {
+			op := list("recv", p.node(sc.Channel))
+			if sc.Value != nil {
+				op = list("send", p.node(sc.Channel), p.node(sc.Value))
+			}
+			if sc.Binding != nil {
+				op = list("=", sc.Binding.Value, op)
+			}
+			items = append(items, list("=>", op, p.block(sc.Body)))
+		}
+		if node.Default != nil {
+			items = append(items, list("=>", "_", p.block(node.Default)))
+		}
+		return list(items...)
+	}
+
+	if p.err == nil {
+		p.err = fmt.Errorf("no s-expression for %T", node)
+	}
+	return "?"
+}
+
+func (p *printer) function(fl *ast.FunctionLiteral) string {
+	items := []string{"fn"}
+	if fl.Generator {
+		items[0] = "fn*"
+	}
+	if fl.Name != "" {
+		items = append(items, fl.Name)
+	}
+	params := []string{}
+	for ix, param := range fl.Parameters {
+		if t := fl.ParamType(ix); t != nil {
+			params = append(params, list(param.Value, typeExpr(t)))
+		} else {
+			params = append(params, param.Value)
+		}
+	}
+	items = append(items, list(params...))
+	if fl.ReturnType != nil {
+		items = append(items, list("->", typeExpr(fl.ReturnType)))
+	}
+	return list(append(items, p.statements(fl.Body.Statements)...)...)
+}
+
+func typeExpr(t *ast.TypeExpr) string {
+	params := []string{}
+	for _, param := range t.Params {
+		params = append(params, typeExpr(param))
+	}
+	switch {
+	case t.Name == "fn":
+		return list("fn", list(params...), typeExpr(t.Return))
+	case len(params) == 0:
+		return t.Name
+	}
+	return list(append([]string{t.Name}, params...)...)
+}
@@ -0,0 +1,182 @@
+// Package sexpr reads programs written as s-expressions, e.g.
+// `(let x (+ 1 2))` or `(fn (a b) (* a b))`, into the same ast the parser
+// produces, and prints any program back out that way. Every node has exactly
+// one s-expression, which makes it an easy format for other tools to emit and
+// a canonical serialization of programs
+package sexpr
+
+import (
+	"fmt"
+	"lang/ast"
+	"strconv"
+	"strings"
+)
+
+// A form is an s-expression before it's given a meaning: an atom, a string,
+// or a list in (), [] or {}
+type form struct {
+	line, column int
+	atom         string // the atom, or the string's unquoted value
+	str          bool
+	open         byte // the list's opening bracket, 0 for atoms and strings
+	list         []*form
+}
+
+// Reader turns s-expression source into an ast.Program
+type Reader struct {
+	file   string
+	src    string
+	pos    int
+	line   int // position of src[pos]
+	column int
+	errors []string
+
+	// The function literals enclosing the form being read, innermost last
+	functions []*ast.FunctionLiteral
+	// How many blocks deep the form being read is; 0 at the top level
+	depth int
+}
+
+// NewReader returns a reader for src, read from the file named file ("" for
+// source that didn't come from a file)
+func NewReader(file, src string) *Reader {
+	return &Reader{file: file, src: src, line: 1, column: 1}
+}
+
+func (r *Reader) Errors() []string {
+	return r.errors
+}
+
+// ReadProgram reads every form in the source as a statement. Forms that are
+// malformed are left out, w/ an error recorded for each
+func (r *Reader) ReadProgram() *ast.Program {
+	program := &ast.Program{Statements: []ast.Statement{}}
+	forms, _ := r.readForms(0)
+	for _, f := range forms {
+		if stmt := r.statement(f); stmt != nil {
+			program.Statements = append(program.Statements, stmt)
+		}
+	}
+	return program
+}
+
+func (r *Reader) errorf(f *form, format string, a ...interface{}) {
+	file := r.file
+	if file == "" {
+		file = "<input>"
+	}
+	msg := fmt.Sprintf(format, a...)
+	r.errors = append(r.errors, fmt.Sprintf("%s:%d:%d: %s", file, f.line, f.column, msg))
+}
+
+var closing = map[byte]byte{'(': ')', '[': ']', '{': '}'}
+
+// readForms reads forms up to the close bracket, which it consumes, or the
+// end of the source when close is 0. It reports false if the source ended
+// in the middle of a form, which has its error recorded already, so the
+// lists it's nested in don't record another one each
+func (r *Reader) readForms(close byte) ([]*form, bool) {
+	forms := []*form{}
+	for {
+		r.skipBlanks()
+		switch ch := r.peek(); {
+		case ch == 0 && close == 0:
+			return forms, true
+		case ch == 0:
+			r.errorf(&form{line: r.line, column: r.column}, "expected %q, got end of input", close)
+			return forms, false
+		case ch == close:
+			r.advance()
+			return forms, true
+		case ch == ')' || ch == ']' || ch == '}':
+			r.errorf(&form{line: r.line, column: r.column}, "unexpected %q", ch)
+			r.advance()
+		default:
+			f := r.readForm()
+			if f == nil && r.peek() == 0 {
+				return forms, false
+			}
+			if f != nil {
+				forms = append(forms, f)
+			}
+		}
+	}
+}
+
+func (r *Reader) readForm() *form {
+	f := &form{line: r.line, column: r.column}
+	switch ch := r.peek(); {
+	case ch == '[' && r.pos+1 < len(r.src) && r.src[r.pos+1] == ']':
+		// `[]`, the empty array, is an atom so it can head an index
+		r.advance()
+		r.advance()
+		f.atom = "[]"
+	case ch == '(' || ch == '[' || ch == '{':
+		r.advance()
+		f.open = ch
+		list, ok := r.readForms(closing[ch])
+		if !ok {
+			return nil
+		}
+		f.list = list
+	case ch == '"':
+		start := r.pos
+		r.advance()
+		for r.peek() != '"' && r.peek() != 0 {
+			if r.peek() == '\\' {
+				r.advance()
+			}
+			r.advance()
+		}
+		r.advance()
+		value, err := strconv.Unquote(r.src[start:r.pos])
+		if err != nil {
+			r.errorf(f, "invalid string %s", r.src[start:r.pos])
+			return nil
+		}
+		f.atom, f.str = value, true
+	default:
+		start := r.pos
+		for !strings.ContainsRune(" \t\r\n()[]{}\";", rune(r.peek())) && r.peek() != 0 {
+			r.advance()
+		}
+		f.atom = r.src[start:r.pos]
+	}
+	return f
+}
+
+// skipBlanks skips whitespace and `;` comments, which run to the end of the
+// line
+func (r *Reader) skipBlanks() {
+	for {
+		switch r.peek() {
+		case ' ', '\t', '\r', '\n':
+			r.advance()
+		case ';':
+			for r.peek() != '\n' && r.peek() != 0 {
+				r.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (r *Reader) peek() byte {
+	if r.pos >= len(r.src) {
+		return 0
+	}
+	return r.src[r.pos]
+}
+
+func (r *Reader) advance() {
+	if r.pos >= len(r.src) {
+		return
+	}
+	if r.src[r.pos] == '\n' {
+		r.line += 1
+		r.column = 0
+	}
+	r.column += 1
+	r.pos += 1
+}
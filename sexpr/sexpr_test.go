@@ -0,0 +1,205 @@
+package sexpr
+
+import (
+	"lang/ast"
+	"lang/evaluator"
+	"lang/lexer"
+	"lang/object"
+	"lang/parser"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func read(t *testing.T, input string) *ast.Program {
+	r := NewReader("", input)
+	program := r.ReadProgram()
+	if len(r.Errors()) != 0 {
+		t.Fatalf("reader errors for %q: %v", input, r.Errors())
+	}
+	return program
+}
+
+func print(t *testing.T, node ast.Node) string {
+	out, err := Print(node)
+	if err != nil {
+		t.Fatalf("print failed: %s", err)
+	}
+	return out
+}
+
+func TestReadProgram(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the same program in the ordinary syntax
+	}{
+		{`(let x (+ 1 2))`, `let x = 1 + 2;`},
+		{`(fn (a b) (* a b))`, `fn(a, b) { a * b }`},
+		{`(fn add ((a int) b) (-> int) (+ a b)) (add 1 (- 2))`, `fn add(a: int, b) -> int { a + b } add(1, -2)`},
+		{`(const (xs (array int)) [1 2]) ([] xs 0)`, `const xs: array[int] = [1, 2]; xs[0]`},
+		{`(let (f (fn (int) (| int null))) g)`, `let f: fn(int) -> int | null = g;`},
+		{`(if (< x 1) (do (= x 1) x) (- x))`, `if (x < 1) { x = 1; x } else { -x }`},
+		{"; a comment\n(puts \"a b\" :ok {\"k\" null} [])", `puts("a b", :ok, {"k": null}, [])`},
+		{`(. p x) ((. p norm)) (? (f))`, `p.x; p.norm(); f()?`},
+		{`(struct Point x y) (enum Shape (Circle r) Empty) (impl Point (fn norm (self) (. self x)))`,
+			`struct Point { x, y } enum Shape { Circle(r), Empty } impl Point { fn norm(self) { self.x } }`},
+		{`(match s (=> (Circle r) r) (=> _ (do 0)))`, `match (s) { Circle(r) => r, _ => { 0 } }`},
+		{`(try (f) (catch e (g e)) (finally (h)))`, `try { f() } catch (e) { g(e) } finally { h() }`},
+		{`(fn* gen () (for x xs (yield x)))`, `fn* gen() { for (x in xs) { yield x } }`},
+		{`(await (spawn (f 1)))`, `await spawn f(1)`},
+		{`(select (=> (= v (recv a)) v) (=> (send b 1) 0) (=> _ (- 1)))`, `select { v = recv(a) => v, send(b, 1) => 0, _ => -1 }`},
+		{`(import "math" m) (export (let y 1)) (fn (c) (defer (close c))) (throw "x")`,
+			`import "math" as m; export let y = 1; fn(c) { defer close(c) } throw "x"`},
+		{`(let unless (macro (c body) (quote (if (! (unquote c)) (unquote body)))))`,
+			`let unless = macro(c, body) { quote(if (!unquote(c)) { unquote(body) }) };`},
+		{`(let n 1) ($ "n is " (+ n 1) "!")`, `let n = 1; "n is ${n + 1}!"`},
+		{`(infix <> SUM left (fn (a b) a)) (<> 1 2)`, `infix <> (precedence: SUM, assoc: left) = fn(a, b) { a }; 1 <> 2`},
+	}
+	for _, tt := range tests {
+		actual := read(t, tt.input)
+		expected := parse(t, tt.expected)
+		if actual.String() != expected.String() {
+			t.Errorf("%s: expected=%q, got=%q", tt.input, expected.String(), actual.String())
+		}
+		if print(t, actual) != print(t, expected) {
+			t.Errorf("%s: printed differently from the parsed program: %q vs %q",
+				tt.input, print(t, actual), print(t, expected))
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x = 1 + 2 * 3;`, `(let x (+ 1 (* 2 3)))`},
+		{`fn f(a: int) -> Option[int] { if (a > 0) { Some(a) } else { None } }`,
+			`(fn f ((a int)) (-> (Option int)) (if (> a 0) (do (Some a)) (do None)))`},
+		{`{"b": 2, "a": [1, -1]}`, `{"a" [1 (- 1)] "b" 2}`},
+		{`let s = "C:\dir";`, `(let s "C:\\dir")`},
+		{`[][0]; [](1)`, "([] [] 0)\n([ ] 1)"},
+		{"let a = 1;\nputs(a)", "(let a 1)\n(puts a)"},
+	}
+	for _, tt := range tests {
+		if actual := print(t, parse(t, tt.input)); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	s := &ast.StringLiteral{Value: `say "hi"`}
+	if printed := print(t, s); printed != `"say \"hi\""` {
+		t.Errorf("expected=%q, got=%q", `"say \"hi\""`, printed)
+	}
+	if read := read(t, print(t, s)).Statements[0].String(); read != s.Value {
+		t.Errorf("expected=%q, got=%q", s.Value, read)
+	}
+
+	type custom struct {
+		ast.Custom
+		ast.Identifier
+	}
+	if _, err := Print(&custom{}); err == nil {
+		t.Errorf("expected an error printing a node w/o an s-expression")
+	}
+}
+
+// Printing then reading back any program gives the same program
+func TestRoundTrip(t *testing.T) {
+	tests := []string{
+		`let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }; fib(10)`,
+		`struct P { x } impl P { fn get(self) -> int { self.x } } let p = P(1); p.x = 2; p.get()`,
+		`enum E { A, B(x) } match (E.B(1)) { E.B(x) => x, E.A => 0 }`,
+		`let h = {:a: [1, 2]}; let g = {"b": fn*() { yield 1 }}; h[:a][0]`,
+		`let xs = []; [][0]`,
+		`for (x in [1, 2]) { try { throw x } catch { 0 } }`,
+		`let c = chan(1); select { send(c, 1) => :sent }`,
+		`const f: fn(int, string) -> array[int | string] = fn(a: int, b: string) { [a, b] };`,
+		`let n = 1; "${n}: ${"inner ${[n]}"}"`,
+		`infix ** (precedence: PRODUCT, assoc: right) = fn(a, b) { a * b }; 2 ** 3 ** 4`,
+	}
+	for _, input := range tests {
+		expected := parse(t, input)
+		printed := print(t, expected)
+		actual := read(t, printed)
+		if actual.String() != expected.String() {
+			t.Errorf("%s: expected=%q, got=%q", printed, expected.String(), actual.String())
+		}
+		if reprinted := print(t, actual); reprinted != printed {
+			t.Errorf("expected=%q, got=%q", printed, reprinted)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(let x 1`, `<input>:1:9: expected ')', got end of input`},
+		{`(`, `<input>:1:2: expected ')', got end of input`},
+		{`(let x [1 (f 2`, `<input>:1:15: expected ')', got end of input`},
+		{`(let x "abc`, `<input>:1:8: invalid string "abc`},
+		{`(let x 1))`, `<input>:1:10: unexpected ')'`},
+		{`(let x)`, `<input>:1:1: let takes 2 arguments, got 1`},
+		{`(return 1 2)`, `<input>:1:1: return takes 1 argument, got 2`},
+		{`(let 1 x)`, `<input>:1:6: expected an identifier, got 1`},
+		{`(if)`, `<input>:1:1: if takes 2 to 3 arguments, got 0`},
+		{"\n  (+ 1 2 3)", `<input>:2:3: operator + takes 2 operands, got 3`},
+		{`()`, `<input>:1:1: empty list`},
+		{`(let x (let y 1))`, `<input>:1:8: let is not an expression`},
+		{`(= 1 2)`, `<input>:1:4: cannot assign to 1`},
+		{`(yield 1)`, `<input>:1:1: yield outside function`},
+		{`(fn f (x) (import "m"))`, `<input>:1:11: import must be at the top level`},
+		{`(fn () (defer x))`, `<input>:1:15: defer takes a call, got x`},
+		{`(defer (f))`, `<input>:1:1: defer outside function`},
+		{`(match x (y 1))`, `<input>:1:10: expected (=> pattern body), got (...)`},
+		{`(try (f))`, `<input>:1:1: Expected catch or finally after try block`},
+		{`(let (x (fn int)) 1)`, `<input>:1:9: expected (fn (params) result), got (...)`},
+		{`(select (=> (f a) 1))`, `<input>:1:13: invalid select case: (...)`},
+		{`"abc`, `<input>:1:1: invalid string "abc`},
+		{`($ "a" x "b" y)`, `<input>:1:1: interpolated string needs text around each expression, got 4 parts`},
+		{`($ "a" x y)`, `<input>:1:10: expected text, got y`},
+	}
+	for _, tt := range tests {
+		r := NewReader("", tt.input)
+		r.ReadProgram()
+		if len(r.Errors()) != 1 || r.Errors()[0] != tt.expected {
+			t.Errorf("%s: expected=%q, got=%q", tt.input, tt.expected, r.Errors())
+		}
+	}
+
+	r := NewReader("gen.mks", "(x))")
+	r.ReadProgram()
+	if len(r.Errors()) != 1 || !strings.HasPrefix(r.Errors()[0], "gen.mks:1:4:") {
+		t.Errorf("expected the file name in errors, got=%q", r.Errors())
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(fn count (n acc) (if (== n 0) acc (count (- n 1) (+ acc 1)))) (count 100000 0)`, "100000"},
+		{`(let g (fn () (yield 1) (yield 2))) (let it (g)) [(next it) (next it)]`, "[Option.Some(1), Option.Some(2)]"},
+		{`(let x 1) (= x (+ x 1)) (if (> x 1) "big" "small")`, "big"},
+		{`(let h {:a 1}) ([] h :a)`, "1"},
+		{`(let n 4) ($ "count: " (+ n 1) "")`, "count: 5"},
+	}
+	for _, tt := range tests {
+		program := read(t, tt.input)
+		evaluated := evaluator.Eval(program, object.NewEnvironment())
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: expected=%q, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+}
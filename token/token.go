@@ -5,6 +5,9 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	File    string // source file name, "" when lexing a REPL line or a string
+	Line    int    // 1-based position of the token's first character
+	Column  int
 }
 
 const (
@@ -14,8 +17,16 @@ const (
 	IDENT    = "IDENT" // add, foobar, x, y, ...
 	INT      = "INT"
 	STRING   = "STRING"
+	SYMBOL   = "SYMBOL" // :ok, :error, ...
 	LBRACKET = "["
 	RBRACKET = "]"
+
+	// The text of an interpolated string around its embedded expressions:
+	// "a ${x} b ${y} c" is HEAD(a ) x MIDDLE( b ) y TAIL( c)
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
 	// Operators
 	ASSIGN   = "="
 	PLUS     = "+"
@@ -23,6 +34,7 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	QUESTION = "?"
 
 	LT = "<"
 	GT = ">"
@@ -30,22 +42,55 @@ const (
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	FAT_ARROW = "=>"
+	ARROW     = "->"
+	PIPE      = "|"
+
 	// Delimiters
 	COMMA     = ","
+	DOT       = "."
 	SEMICOLON = ";"
 	LPAREN    = "("
 	RPAREN    = ")"
 	LBRACE    = "{"
 	RBRACE    = "}"
+	// Layout, only in the indentation syntax
+	NEWLINE = "NEWLINE"
+	INDENT  = "INDENT"
+	DEDENT  = "DEDENT"
 	// Keywords
 	// 1343456
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	DEFER    = "DEFER"
+	YIELD    = "YIELD"
+	FOR      = "FOR"
+	IN       = "IN"
+	SPAWN    = "SPAWN"
+	AWAIT    = "AWAIT"
+	SELECT   = "SELECT"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
+	AS       = "AS"
+	NULL     = "NULL"
+	MATCH    = "MATCH"
+	STRUCT   = "STRUCT"
+	IMPL     = "IMPL"
+	ENUM     = "ENUM"
+	MACRO    = "MACRO"
+	INFIX    = "INFIX"
+	DEF      = "DEF"
+	ELIF     = "ELIF"
 
 	COLON = ":"
 )
@@ -0,0 +1,660 @@
+// Package typecheck infers and checks the optional type annotations on let
+// bindings, function parameters and return types. It runs on a parsed
+// program and only reports diagnostics: the evaluator ignores annotations,
+// and anything unannotated is typed as Any so it never trips the checker
+package typecheck
+
+import (
+	"fmt"
+	"lang/ast"
+	"lang/token"
+	"reflect"
+)
+
+// Diagnostic is a type error found by Check
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	file := d.File
+	if file == "" {
+		file = "<input>"
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", file, d.Line, d.Column, d.Message)
+}
+
+type binding struct {
+	typ       Type
+	annotated bool // assignments must keep to typ
+}
+
+// function is the function literal being checked
+type function struct {
+	declared Type   // annotated return type, nil if none
+	returns  []Type // types of its return statements
+}
+
+type checker struct {
+	scopes      []map[string]*binding
+	functions   []*function
+	types       map[string]bool // struct and enum names
+	diagnostics []Diagnostic
+}
+
+// Check type checks program and returns what it found, in source order
+func Check(program *ast.Program) []Diagnostic {
+	c := &checker{types: map[string]bool{"Option": true, "Result": true}}
+	c.pushScope()
+	c.declareTypes(program.Statements)
+	c.checkStatements(program.Statements)
+	return c.diagnostics
+}
+
+func (c *checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		File:    tok.File,
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (c *checker) pushScope() { c.scopes = append(c.scopes, map[string]*binding{}) }
+func (c *checker) popScope()  { c.scopes = c.scopes[:len(c.scopes)-1] }
+
+func (c *checker) declare(name string, typ Type, annotated bool) {
+	c.scopes[len(c.scopes)-1][name] = &binding{typ: typ, annotated: annotated}
+}
+
+func (c *checker) lookup(name string) *binding {
+	for ix := len(c.scopes) - 1; ix >= 0; ix-- {
+		if b, ok := c.scopes[ix][name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// declareTypes registers the struct and enum names of the whole program up
+// front, so annotations can refer to types declared further down
+func (c *checker) declareTypes(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			stmt = export.Statement
+		}
+		switch stmt := stmt.(type) {
+		case *ast.StructStatement:
+			c.types[stmt.Name.Value] = true
+		case *ast.EnumStatement:
+			c.types[stmt.Name.Value] = true
+		}
+	}
+}
+
+// resolve turns an annotation into a Type, reporting unknown names
+func (c *checker) resolve(te *ast.TypeExpr) Type {
+	if te == nil {
+		return Any
+	}
+	args := []Type{}
+	for _, p := range te.Params {
+		args = append(args, c.resolve(p))
+	}
+
+	arity := func(n int) bool {
+		if len(args) != n {
+			c.errorf(te.Token, "type %s takes %d type arguments, got %d", te.Name, n, len(args))
+			return false
+		}
+		return true
+	}
+
+	switch te.Name {
+	case "|":
+		return Join(args...)
+	case "fn":
+		return &Func{Params: args, Return: c.resolve(te.Return)}
+	case "any":
+		return Any
+	case "int":
+		return Int
+	case "string":
+		return String
+	case "bool":
+		return Bool
+	case "null":
+		return Null
+	case "symbol":
+		return Symbol
+	case "array":
+		if len(args) == 0 {
+			return &Array{Elem: Any}
+		}
+		if !arity(1) {
+			return Any
+		}
+		return &Array{Elem: args[0]}
+	case "hash":
+		if len(args) == 0 {
+			return &Hash{Key: Any, Value: Any}
+		}
+		if !arity(2) {
+			return Any
+		}
+		return &Hash{Key: args[0], Value: args[1]}
+	}
+
+	if !c.types[te.Name] {
+		c.errorf(te.Token, "unknown type %s", te.Name)
+		return Any
+	}
+	return &Named{Name: te.Name, Args: args}
+}
+
+// checkStatements checks a block's statements in order and returns the type
+// of the value the block evaluates to
+func (c *checker) checkStatements(stmts []ast.Statement) Type {
+	c.hoistFunctions(stmts)
+
+	var result Type = Null
+	for _, stmt := range stmts {
+		result = c.checkStatement(stmt)
+	}
+	return result
+}
+
+// hoistFunctions declares a block's function statements before checking any
+// of it, the same way the evaluator binds them. Their types come from the
+// annotations alone since the bodies haven't been looked at yet
+func (c *checker) hoistFunctions(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			stmt = export.Statement
+		}
+		if decl, ok := stmt.(*ast.FunctionStatement); ok {
+			c.declare(decl.Name.Value, c.signature(decl.Function), false)
+		}
+	}
+}
+
+// signature is the type of fl as stated by its annotations
+func (c *checker) signature(fl *ast.FunctionLiteral) *Func {
+	fn := &Func{Return: c.resolve(fl.ReturnType)}
+	for ix := range fl.Parameters {
+		fn.Params = append(fn.Params, c.resolve(fl.ParamType(ix)))
+	}
+	if fl.Generator {
+		fn.Return = &Named{Name: "generator"}
+	}
+	return fn
+}
+
+func (c *checker) checkBlock(block *ast.BlockStatement) Type {
+	if block == nil {
+		return Null
+	}
+	c.pushScope()
+	defer c.popScope()
+	return c.checkStatements(block.Statements)
+}
+
+func (c *checker) checkStatement(stmt ast.Statement) Type {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		return c.check(stmt.Expression)
+
+	case *ast.LetStatement:
+		c.checkLet(stmt)
+
+	case *ast.ReturnStatement:
+		var typ Type = Null
+		if stmt.ReturnValue != nil {
+			typ = c.check(stmt.ReturnValue)
+		}
+		if len(c.functions) > 0 {
+			c.checkReturn(stmt.Token, typ)
+		}
+
+	case *ast.FunctionStatement:
+		// already declared by hoistFunctions; refine it w/ the inferred
+		// return type
+		c.lookup(stmt.Name.Value).typ = c.check(stmt.Function)
+
+	case *ast.StructStatement:
+		ctor := &Func{Return: &Named{Name: stmt.Name.Value}}
+		for range stmt.Fields {
+			ctor.Params = append(ctor.Params, Any)
+		}
+		c.declare(stmt.Name.Value, ctor, false)
+
+	case *ast.EnumStatement:
+		c.declare(stmt.Name.Value, Any, false)
+
+	case *ast.ImplStatement:
+		for _, m := range stmt.Methods {
+			c.check(m.Function)
+		}
+
+	case *ast.InfixStatement:
+		c.check(stmt.Function)
+
+	case *ast.ThrowStatement:
+		c.check(stmt.Value)
+
+	case *ast.DeferStatement:
+		c.check(stmt.Call)
+
+	case *ast.ImportStatement:
+		if stmt.Alias != nil {
+			c.declare(stmt.Alias.Value, Any, false)
+		}
+
+	case *ast.ExportStatement:
+		c.checkStatement(stmt.Statement)
+
+	case *ast.BlockStatement:
+		return c.checkBlock(stmt)
+	}
+	return Null
+}
+
+func (c *checker) checkLet(stmt *ast.LetStatement) {
+	name := stmt.Name.Value
+	if stmt.Type == nil {
+		// bound before the value is checked so a function can refer to
+		// itself; its real type is only known afterwards
+		c.declare(name, Any, false)
+		c.lookup(name).typ = c.check(stmt.Value)
+		return
+	}
+
+	declared := c.resolve(stmt.Type)
+	c.declare(name, declared, true)
+	if typ := c.check(stmt.Value); !Assignable(declared, typ) {
+		c.errorf(tokenOf(stmt.Value), "cannot use %s as %s in let %s", typ, declared, name)
+	}
+}
+
+func (c *checker) checkReturn(tok token.Token, typ Type) {
+	fn := c.functions[len(c.functions)-1]
+	fn.returns = append(fn.returns, typ)
+	if fn.declared != nil && !Assignable(fn.declared, typ) {
+		c.errorf(tok, "cannot return %s from function returning %s", typ, fn.declared)
+	}
+}
+
+// check infers the type of an expression, reporting what it finds wrong
+// along the way
+func (c *checker) check(node ast.Expression) Type {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.InterpolatedString:
+		// Any value can be embedded, so there's nothing to check but the parts
+		for _, part := range node.Parts {
+			c.check(part)
+		}
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.NullLiteral:
+		return Null
+	case *ast.SymbolLiteral:
+		return Symbol
+
+	case *ast.Identifier:
+		if b := c.lookup(node.Value); b != nil {
+			return b.typ
+		}
+		if node.Value == "None" {
+			return &Named{Name: "Option", Args: []Type{Any}}
+		}
+		return Any
+
+	case *ast.PrefixExpression:
+		return c.checkPrefix(node)
+
+	case *ast.InfixExpression:
+		return c.checkInfix(node)
+
+	case *ast.IfExpression:
+		c.check(node.Condition)
+		return Join(c.checkBlock(node.Consequence), c.checkBlock(node.Alternative))
+
+	case *ast.ArrayLiteral:
+		if len(node.Elements) == 0 {
+			return &Array{Elem: Any}
+		}
+		elems := []Type{}
+		for _, el := range node.Elements {
+			elems = append(elems, c.check(el))
+		}
+		return &Array{Elem: Join(elems...)}
+
+	case *ast.HashLiteral:
+		if len(node.Pairs) == 0 {
+			return &Hash{Key: Any, Value: Any}
+		}
+		keys, values := []Type{}, []Type{}
+		for k, v := range node.Pairs {
+			keys = append(keys, c.check(k))
+			values = append(values, c.check(v))
+		}
+		return &Hash{Key: Join(keys...), Value: Join(values...)}
+
+	case *ast.IndexExpression:
+		return c.checkIndex(node)
+
+	case *ast.FunctionLiteral:
+		return c.checkFunction(node)
+
+	case *ast.CallExpression:
+		return c.checkCall(node)
+
+	case *ast.AssignExpression:
+		return c.checkAssign(node)
+
+	case *ast.MemberExpression:
+		c.check(node.Object)
+		return Any
+
+	case *ast.MatchExpression:
+		c.check(node.Subject)
+		arms := []Type{}
+		for _, arm := range node.Arms {
+			c.pushScope()
+			for _, name := range patternNames(arm.Pattern) {
+				c.declare(name, Any, false)
+			}
+			arms = append(arms, c.checkBlock(arm.Body))
+			c.popScope()
+		}
+		return Join(arms...)
+
+	case *ast.TryExpression:
+		block := c.checkBlock(node.Block)
+		c.pushScope()
+		if node.CatchParam != nil {
+			c.declare(node.CatchParam.Value, Any, false)
+		}
+		catch := c.checkBlock(node.Catch)
+		c.popScope()
+		c.checkBlock(node.Finally)
+		return Join(block, catch)
+
+	case *ast.PropagateExpression:
+		if named, ok := c.check(node.Left).(*Named); ok && len(named.Args) > 0 &&
+			(named.Name == "Option" || named.Name == "Result") {
+			return named.Args[0]
+		}
+		return Any
+
+	case *ast.ForExpression:
+		var elem Type = Any
+		switch iterable := c.check(node.Iterable).(type) {
+		case *Array:
+			elem = iterable.Elem
+		case *Hash:
+			elem = iterable.Key
+		case *Named:
+			if iterable == String {
+				elem = String
+			}
+		}
+		c.pushScope()
+		c.declare(node.Variable.Value, elem, false)
+		c.checkBlock(node.Body)
+		c.popScope()
+		return Null
+
+	case *ast.YieldExpression:
+		if node.Value != nil {
+			c.check(node.Value)
+		}
+		return Any
+
+	case *ast.SpawnExpression:
+		c.check(node.Call)
+		return Any
+
+	case *ast.AwaitExpression:
+		c.check(node.Value)
+		return Any
+
+	case *ast.SelectExpression:
+		arms := []Type{}
+		for _, sc := range node.Cases {
+			c.check(sc.Channel)
+			if sc.Value != nil {
+				c.check(sc.Value)
+			}
+			c.pushScope()
+			if sc.Binding != nil {
+				c.declare(sc.Binding.Value, Any, false)
+			}
+			arms = append(arms, c.checkBlock(sc.Body))
+			c.popScope()
+		}
+		if node.Default != nil {
+			arms = append(arms, c.checkBlock(node.Default))
+		}
+		return Join(arms...)
+	}
+	return Any
+}
+
+func (c *checker) checkPrefix(node *ast.PrefixExpression) Type {
+	right := c.check(node.Right)
+	switch node.Operator {
+	case "!":
+		return Bool
+	case "-":
+		if !Assignable(Int, right) {
+			c.errorf(node.Token, "operator - not defined on %s", right)
+		}
+		return Int
+	}
+	return Any
+}
+
+func (c *checker) checkInfix(node *ast.InfixExpression) Type {
+	left, right := c.check(node.Left), c.check(node.Right)
+
+	switch node.Operator {
+	case "==", "!=":
+		return Bool
+	case "+":
+		switch {
+		case left == Any || right == Any:
+			return Any
+		case same(left, Int) && same(right, Int):
+			return Int
+		case same(left, String) && same(right, String):
+			return String
+		}
+	case "-", "*", "/", "<", ">":
+		if Assignable(Int, left) && Assignable(Int, right) {
+			if node.Operator == "<" || node.Operator == ">" {
+				return Bool
+			}
+			return Int
+		}
+	default:
+		return Any
+	}
+
+	c.errorf(node.Token, "operator %s not defined on %s and %s", node.Operator, left, right)
+	return Any
+}
+
+func (c *checker) checkIndex(node *ast.IndexExpression) Type {
+	left, index := c.check(node.Left), c.check(node.Index)
+	switch left := left.(type) {
+	case *Array:
+		if !Assignable(Int, index) {
+			c.errorf(tokenOf(node.Index), "cannot index %s w/ %s", left, index)
+		}
+		return left.Elem
+	case *Hash:
+		if !Assignable(left.Key, index) {
+			c.errorf(tokenOf(node.Index), "cannot index %s w/ %s", left, index)
+		}
+		return left.Value
+	}
+	if same(left, String) {
+		return String
+	}
+	return Any
+}
+
+func (c *checker) checkFunction(fl *ast.FunctionLiteral) Type {
+	sig := c.signature(fl)
+	fn := &function{}
+	if fl.ReturnType != nil && !fl.Generator {
+		fn.declared = sig.Return
+	}
+
+	c.functions = append(c.functions, fn)
+	c.pushScope()
+	for ix, p := range fl.Parameters {
+		c.declare(p.Value, sig.Params[ix], fl.ParamType(ix) != nil)
+	}
+	last := c.checkStatements(fl.Body.Statements)
+	// a body ending in a return statement has no value of its own
+	if stmts := fl.Body.Statements; !fl.Generator && len(stmts) == 0 {
+		c.checkReturn(fl.Body.Token, last)
+	} else if !fl.Generator && !isReturn(stmts[len(stmts)-1]) {
+		c.checkReturn(tokenOf(stmts[len(stmts)-1]), last)
+	}
+	c.popScope()
+	c.functions = c.functions[:len(c.functions)-1]
+
+	if !fl.Generator && fn.declared == nil {
+		sig.Return = Join(fn.returns...)
+	}
+	return sig
+}
+
+func isReturn(stmt ast.Statement) bool {
+	_, ok := stmt.(*ast.ReturnStatement)
+	return ok
+}
+
+func (c *checker) checkCall(node *ast.CallExpression) Type {
+	callee := c.check(node.Function)
+	args := []Type{}
+	for _, arg := range node.Arguments {
+		args = append(args, c.check(arg))
+	}
+
+	if ident, ok := node.Function.(*ast.Identifier); ok && c.lookup(ident.Value) == nil && len(args) == 1 {
+		switch ident.Value {
+		case "Some":
+			return &Named{Name: "Option", Args: []Type{args[0]}}
+		case "Ok":
+			return &Named{Name: "Result", Args: []Type{args[0], Any}}
+		case "Err":
+			return &Named{Name: "Result", Args: []Type{Any, args[0]}}
+		}
+	}
+
+	fn, ok := callee.(*Func)
+	if !ok {
+		return Any
+	}
+	if len(args) != len(fn.Params) {
+		c.errorf(node.Token, "wrong number of arguments: want %d, got %d", len(fn.Params), len(args))
+		return fn.Return
+	}
+	for ix, arg := range args {
+		if !Assignable(fn.Params[ix], arg) {
+			c.errorf(tokenOf(node.Arguments[ix]), "cannot use %s as %s in argument %d", arg, fn.Params[ix], ix+1)
+		}
+	}
+	return fn.Return
+}
+
+func (c *checker) checkAssign(node *ast.AssignExpression) Type {
+	value := c.check(node.Value)
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		b := c.lookup(target.Value)
+		switch {
+		case b == nil:
+		case b.annotated:
+			if !Assignable(b.typ, value) {
+				c.errorf(tokenOf(node.Value), "cannot assign %s to %s (%s)", value, target.Value, b.typ)
+			}
+		case !same(b.typ, value):
+			// unannotated bindings take whatever they're given
+			b.typ = Any
+		}
+	default:
+		c.check(target)
+	}
+	return value
+}
+
+// patternNames are the identifiers a match pattern may bind
+func patternNames(pattern ast.Expression) []string {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		return []string{pattern.Value}
+	case *ast.CallExpression:
+		names := []string{}
+		for _, arg := range pattern.Arguments {
+			names = append(names, patternNames(arg)...)
+		}
+		return names
+	case *ast.ArrayLiteral:
+		names := []string{}
+		for _, el := range pattern.Elements {
+			names = append(names, patternNames(el)...)
+		}
+		return names
+	case *ast.HashLiteral:
+		names := []string{}
+		for _, v := range pattern.Pairs {
+			names = append(names, patternNames(v)...)
+		}
+		return names
+	}
+	return nil
+}
+
+// tokenOf is the first token of node. Most node types keep it in a field
+// named Token; those starting w/ an operand point at the operand instead
+func tokenOf(node ast.Node) token.Token {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		return tokenOf(node.Expression)
+	case *ast.CallExpression:
+		return tokenOf(node.Function)
+	case *ast.InfixExpression:
+		return tokenOf(node.Left)
+	case *ast.IndexExpression:
+		return tokenOf(node.Left)
+	case *ast.MemberExpression:
+		return tokenOf(node.Object)
+	case *ast.PropagateExpression:
+		return tokenOf(node.Left)
+	case *ast.AssignExpression:
+		return tokenOf(node.Target)
+	}
+
+	v := reflect.ValueOf(node)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		if field := v.Elem().FieldByName("Token"); field.IsValid() {
+			if tok, ok := field.Interface().(token.Token); ok {
+				return tok
+			}
+		}
+	}
+	return token.Token{}
+}
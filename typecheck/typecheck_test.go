@@ -0,0 +1,96 @@
+package typecheck
+
+import (
+	"lang/lexer"
+	"lang/parser"
+	"testing"
+)
+
+func check(t *testing.T, input string) []Diagnostic {
+	p := parser.New(lexer.NewFile("test.mk", input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Check(program)
+}
+
+func TestWellTyped(t *testing.T) {
+	tests := []string{
+		`let x: int = 1; let y = x + 2; y * 3`,
+		`let s: string = "a" + "b";`,
+		`let xs: array[int] = [1, 2, 3]; let x: int = xs[0];`,
+		`let h: hash[string, int] = {"a": 1}; let v: int = h["a"];`,
+		`let empty: array[string] = [];`,
+		`let x: int | null = null; x = 1;`,
+		`fn add(a: int, b: int) -> int { a + b } let n: int = add(1, 2);`,
+		`let f: fn(int) -> bool = fn(n) { n > 0 };`,
+		`let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) }; apply(fn(n: int) -> int { n + 1 }, 2)`,
+		`fn sign(n: int) -> int { if (n < 0) { return -1 } 1 }`,
+		`let o: Option[int] = Some(1); let r: Result[int, string] = Err("no");`,
+		`struct Point { x, y } let p: Point = Point(1, 2);`,
+		`let untyped = fn(a, b) { a + b }; untyped("x", 1)`,
+		`let x = 1; x = "one"; x + "s"`,
+		`let g = fn() -> Shape { Shape.Circle(1) }; enum Shape { Circle(r) }`,
+		`for (s in ["a", "b"]) { let t: string = s; }`,
+		`let n = 1; let s: string = "n is ${n + 1}";`,
+	}
+	for _, input := range tests {
+		if diags := check(t, input); len(diags) != 0 {
+			t.Errorf("%q: unexpected diagnostics %v", input, diags)
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x: int = "one";`, `test.mk:1:14: cannot use string as int in let x`},
+		{`let x: int = 1;
+x = true;`, `test.mk:2:5: cannot assign bool to x (int)`},
+		{`1 + "a"`, `test.mk:1:3: operator + not defined on int and string`},
+		{`-"a"`, `test.mk:1:1: operator - not defined on string`},
+		{`fn f(a: int) { a } f("a")`, `test.mk:1:22: cannot use string as int in argument 1`},
+		{`fn f(a, b) { a } f(1)`, `test.mk:1:19: wrong number of arguments: want 2, got 1`},
+		{`fn f() -> string { 1 }`, `test.mk:1:20: cannot return int from function returning string`},
+		{`fn f(n: int) -> int { if (n > 0) { return "pos" } n }`, `test.mk:1:36: cannot return string from function returning int`},
+		{`let x: widget = 1;`, `test.mk:1:8: unknown type widget`},
+		{`let xs: array[int] = [1, "a"];`, `test.mk:1:22: cannot use array[int | string] as array[int] in let xs`},
+		{`let x: int | null = "a";`, `test.mk:1:21: cannot use string as int | null in let x`},
+		{`let f: fn(int) -> int = fn(s: string) { 1 };`, `test.mk:1:25: cannot use fn(string) -> int as fn(int) -> int in let f`},
+		{`let n: int = if (true) { 1 } else { null };`, `test.mk:1:14: cannot use int | null as int in let n`},
+		{`let h: hash[int] = {};`, `test.mk:1:8: type hash takes 2 type arguments, got 1`},
+		{`let s: string = "a ${1 + "b"}";`, `test.mk:1:24: operator + not defined on int and string`},
+		{`let add = fn(a: int, b: int) { a + b }; let s: string = add(1, 2);`, `test.mk:1:57: cannot use int as string in let s`},
+	}
+	for _, tt := range tests {
+		diags := check(t, tt.input)
+		if len(diags) != 1 {
+			t.Errorf("%q: expected 1 diagnostic, got %v", tt.input, diags)
+			continue
+		}
+		if diags[0].String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, diags[0].String())
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		types    []Type
+		expected string
+	}{
+		{[]Type{Int, Int}, "int"},
+		{[]Type{Int, Null, Int}, "int | null"},
+		{[]Type{Int, &Union{Members: []Type{String, Int}}}, "int | string"},
+		{[]Type{Int, Any}, "any"},
+		{[]Type{&Array{Elem: Int}, &Array{Elem: Int}}, "array[int]"},
+	}
+	for _, tt := range tests {
+		if actual := Join(tt.types...).String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
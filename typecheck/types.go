@@ -0,0 +1,170 @@
+package typecheck
+
+import "strings"
+
+// Type is a static type. Two types are the same type iff their String()s are
+// equal
+type Type interface {
+	String() string
+}
+
+// Named is a type known by name: the builtin scalars, a struct or enum, or a
+// generic like Option[int]
+type Named struct {
+	Name string
+	Args []Type
+}
+
+func (n *Named) String() string {
+	if len(n.Args) == 0 {
+		return n.Name
+	}
+	return n.Name + "[" + joinTypes(n.Args) + "]"
+}
+
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string { return "array[" + a.Elem.String() + "]" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "hash[" + h.Key.String() + ", " + h.Value.String() + "]" }
+
+type Func struct {
+	Params []Type
+	Return Type
+}
+
+func (f *Func) String() string { return "fn(" + joinTypes(f.Params) + ") -> " + f.Return.String() }
+
+// Union is a value of any one of its members, e.g. int | null. Members are
+// never unions themselves
+type Union struct {
+	Members []Type
+}
+
+func (u *Union) String() string {
+	members := []string{}
+	for _, m := range u.Members {
+		members = append(members, m.String())
+	}
+	return strings.Join(members, " | ")
+}
+
+var (
+	// Any is the type of everything the checker can't see through:
+	// unannotated parameters, builtins, imports, ... It is compatible w/
+	// every type in both directions
+	Any    Type = &Named{Name: "any"}
+	Int    Type = &Named{Name: "int"}
+	String Type = &Named{Name: "string"}
+	Bool   Type = &Named{Name: "bool"}
+	Null   Type = &Named{Name: "null"}
+	Symbol Type = &Named{Name: "symbol"}
+)
+
+func joinTypes(types []Type) string {
+	strs := []string{}
+	for _, t := range types {
+		strs = append(strs, t.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+func same(a, b Type) bool { return a.String() == b.String() }
+
+// Assignable reports whether a value of type src can be used where dst is
+// expected
+func Assignable(dst, src Type) bool {
+	if dst == Any || src == Any {
+		return true
+	}
+	if u, ok := src.(*Union); ok {
+		for _, m := range u.Members {
+			if !Assignable(dst, m) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch dst := dst.(type) {
+	case *Union:
+		for _, m := range dst.Members {
+			if Assignable(m, src) {
+				return true
+			}
+		}
+		return false
+	case *Named:
+		src, ok := src.(*Named)
+		if !ok || src.Name != dst.Name {
+			return false
+		}
+		for ix := range dst.Args {
+			if ix < len(src.Args) && !Assignable(dst.Args[ix], src.Args[ix]) {
+				return false
+			}
+		}
+		return true
+	case *Array:
+		src, ok := src.(*Array)
+		return ok && Assignable(dst.Elem, src.Elem)
+	case *Hash:
+		src, ok := src.(*Hash)
+		return ok && Assignable(dst.Key, src.Key) && Assignable(dst.Value, src.Value)
+	case *Func:
+		src, ok := src.(*Func)
+		if !ok || len(src.Params) != len(dst.Params) {
+			return false
+		}
+		for ix := range dst.Params {
+			// parameters are contravariant: src must accept whatever dst
+			// callers may pass
+			if !Assignable(src.Params[ix], dst.Params[ix]) {
+				return false
+			}
+		}
+		return Assignable(dst.Return, src.Return)
+	}
+	return false
+}
+
+// Join is the narrowest type that holds values of all of types: the type
+// itself if they agree, otherwise their union. Any absorbs everything
+func Join(types ...Type) Type {
+	members := []Type{}
+	add := func(t Type) {
+		for _, m := range members {
+			if same(m, t) {
+				return
+			}
+		}
+		members = append(members, t)
+	}
+	for _, t := range types {
+		if t == Any {
+			return Any
+		}
+		if u, ok := t.(*Union); ok {
+			for _, m := range u.Members {
+				add(m)
+			}
+		} else {
+			add(t)
+		}
+	}
+
+	switch len(members) {
+	case 0:
+		return Null
+	case 1:
+		return members[0]
+	}
+	return &Union{Members: members}
+}